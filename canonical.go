@@ -0,0 +1,104 @@
+package vhosts
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CanonicalPolicy decides to which hostname XVhost redirects the requests of a vhost
+type CanonicalPolicy int
+
+const (
+	// CanonicalNone serves the vhost on its hostname and every alias ( default )
+	CanonicalNone CanonicalPolicy = iota
+	// CanonicalPrimary redirects the aliases to the primary hostname of the vhost
+	CanonicalPrimary
+	// CanonicalWWW redirects the apex ( example.com ) and the aliases to the www. variant of the primary hostname,
+	// if it is a hostname or alias of the vhost
+	CanonicalWWW
+	// CanonicalApex redirects the www. variant and the aliases to the apex ( example.com ) of the primary hostname,
+	// if it is a hostname or alias of the vhost
+	CanonicalApex
+)
+
+// canonicalPolicyNames are the names of the canonical policies in the JSON and YAML formats
+var canonicalPolicyNames = []string{"none", "primary", "www", "apex"}
+
+// String returns the name of the canonical policy
+func (p CanonicalPolicy) String() string {
+	if p < 0 || int(p) >= len(canonicalPolicyNames) {
+		return "unknown"
+	}
+	return canonicalPolicyNames[p]
+}
+
+// MarshalText returns the name of the canonical policy
+func (p CanonicalPolicy) MarshalText() ([]byte, error) {
+	if p < 0 || int(p) >= len(canonicalPolicyNames) {
+		return nil, errors.New("invalid canonical policy")
+	}
+	return []byte(canonicalPolicyNames[p]), nil
+}
+
+// UnmarshalText sets the canonical policy from its name
+func (p *CanonicalPolicy) UnmarshalText(text []byte) error {
+	for i, name := range canonicalPolicyNames {
+		if strings.EqualFold(string(text), name) {
+			*p = CanonicalPolicy(i)
+			return nil
+		}
+	}
+	return errors.New("invalid canonical policy")
+}
+
+// canonicalHost returns the hostname the request should be redirected to, or false if it is already canonical.
+// The target is built from the primary hostname and must be one of the names of the vhost, a www. or apex variant
+// that isn't served by the vhost would end in a 404.
+func canonicalHost(vhost Vhost, match HostMatch) (string, bool) {
+	entries := vhostEntries(vhost)
+	primary := entries[0].host
+	// a wildcard, template or regex has no single hostname to redirect to
+	if isHostPattern(primary) || strings.HasPrefix(primary, "*") {
+		return "", false
+	}
+
+	var target string
+	switch vhost.Canonical {
+	case CanonicalPrimary:
+		target = primary
+	case CanonicalWWW:
+		target = "www." + strings.TrimPrefix(primary, "www.")
+	case CanonicalApex:
+		target = strings.TrimPrefix(primary, "www.")
+	default:
+		return "", false
+	}
+	if !slices.ContainsFunc(entries, func(entry hostEntry) bool { return entry.host == target }) {
+		return "", false
+	}
+	return target, match.Hostname != target
+}
+
+// canonicalRedirect redirects the request to the canonical hostname of the vhost keeping the scheme, port, path and query.
+// The port is taken from the hostname returned by the HostExtractor, so the port of a backend behind a proxy doesn't leak.
+// It returns false if the request is already on the canonical hostname.
+func canonicalRedirect(c *fiber.Ctx, hostname string, vhost Vhost, match HostMatch) (bool, error) {
+	host, ok := canonicalHost(vhost, match)
+	if !ok {
+		return false, nil
+	}
+
+	// keep an explicit port of the request
+	if _, port := splitHostPort(hostname); port != "" {
+		host += ":" + port
+	}
+
+	status := vhost.RedirectStatus
+	if status == 0 {
+		status = fiber.StatusMovedPermanently
+	}
+	return true, c.Redirect(requestProtocol(c)+"://"+host+string(c.Request().URI().RequestURI()), status)
+}
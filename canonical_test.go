@@ -0,0 +1,105 @@
+package vhosts
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXVhost_CanonicalRedirect(t *testing.T) {
+	vhosts := &Vhosts{}
+
+	primary := NewVhost("example.com", "", "", mockMiddleware, mockErrorHandler)
+	primary.Aliases = []string{"www.example.com", "example.net"}
+	primary.Canonical = CanonicalPrimary
+	assert.Nil(t, vhosts.Add(primary))
+
+	www := NewVhost("www.shop.com", "", "", mockMiddleware, mockErrorHandler)
+	www.Aliases = []string{"shop.com", "legacy-shop.net"}
+	www.Canonical = CanonicalWWW
+	www.RedirectStatus = fiber.StatusPermanentRedirect
+	assert.Nil(t, vhosts.Add(www))
+
+	apex := NewVhost("blog.com", "", "", mockMiddleware, mockErrorHandler)
+	apex.Aliases = []string{"www.blog.com", "blog.net"}
+	apex.Canonical = CanonicalApex
+	assert.Nil(t, vhosts.Add(apex))
+
+	// the www. variant isn't a name of the vhost, there is nothing to redirect to
+	unregistered := NewVhost("store.com", "", "", mockMiddleware, mockErrorHandler)
+	unregistered.Aliases = []string{"store.net"}
+	unregistered.Canonical = CanonicalWWW
+	assert.Nil(t, vhosts.Add(unregistered))
+
+	app := fiber.New()
+	app.Use(XVhost(vhosts))
+
+	tests := []struct {
+		url      string
+		status   int
+		location string
+	}{
+		{"http://example.com/a?b=c", 200, ""},
+		{"http://www.example.com/a?b=c", 301, "http://example.com/a?b=c"},
+		{"http://example.net:8080/a", 301, "http://example.com:8080/a"},
+		{"http://www.shop.com/cart", 200, ""},
+		{"http://shop.com/cart?id=1", 308, "http://www.shop.com/cart?id=1"},
+		// aliases go to the variant of the primary hostname, not of their own
+		{"http://legacy-shop.net/cart", 308, "http://www.shop.com/cart"},
+		{"http://blog.com/", 200, ""},
+		{"http://www.blog.com/post/1", 301, "http://blog.com/post/1"},
+		{"http://blog.net/post/1", 301, "http://blog.com/post/1"},
+		{"http://store.com/", 200, ""},
+		{"http://store.net/", 200, ""},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", tt.url, nil))
+		assert.Nil(t, err, tt.url)
+		assert.Equal(t, tt.status, resp.StatusCode, tt.url)
+		assert.Equal(t, tt.location, resp.Header.Get("Location"), tt.url)
+	}
+}
+
+func TestCanonicalHost_Wildcard(t *testing.T) {
+	// a wildcard primary hostname has nothing to redirect to
+	vhost := NewVhost("*.example.com", "", "", mockMiddleware, mockErrorHandler)
+	vhost.Canonical = CanonicalPrimary
+	_, ok := canonicalHost(vhost, HostMatch{Hostname: "shop.example.com"})
+	assert.False(t, ok)
+}
+
+func TestXVhost_CanonicalRedirect_Proxy(t *testing.T) {
+	vhosts := &Vhosts{}
+	primary := NewVhost("example.com", "", "", mockMiddleware, mockErrorHandler)
+	primary.Aliases = []string{"www.example.com"}
+	primary.Canonical = CanonicalPrimary
+	assert.Nil(t, vhosts.Add(primary))
+
+	// requests made by app.Test come from 0.0.0.0
+	extractor, err := TrustedProxyHostExtractor("0.0.0.0")
+	assert.Nil(t, err)
+	app := fiber.New()
+	app.Use(New(Config{Registry: vhosts, HostExtractor: extractor}))
+
+	tests := []struct {
+		headers  map[string]string
+		location string
+	}{
+		// the port of the backend doesn't leak and the forwarded protocol is kept
+		{map[string]string{"Forwarded": "host=www.example.com;proto=https"}, "https://example.com/a?b=c"},
+		{map[string]string{"X-Forwarded-Host": "www.example.com:8443", "X-Forwarded-Proto": "https"}, "https://example.com:8443/a?b=c"},
+		{map[string]string{"Forwarded": "host=www.example.com;proto=gopher"}, "http://example.com/a?b=c"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://backend:8080/a?b=c", nil)
+		for key, value := range tt.headers {
+			req.Header.Set(key, value)
+		}
+		resp, err := app.Test(req)
+		assert.Nil(t, err, tt.location)
+		assert.Equal(t, 301, resp.StatusCode, tt.location)
+		assert.Equal(t, tt.location, resp.Header.Get("Location"), tt.location)
+	}
+}
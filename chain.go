@@ -0,0 +1,190 @@
+package vhosts
+
+import (
+	"errors"
+	"maps"
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// terminalKey is the locals key of the step executed at the end of a middleware chain, it calls the handler of the
+// vhost on the ctx of the caller
+type terminalKey struct{}
+
+// chainErrorKey is the locals key of the error returned by a middleware chain
+type chainErrorKey struct{}
+
+// Use appends middleware to the global chain executed for every vhost before its handler
+func (v *Vhosts) Use(middleware ...FiberHandler) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.middleware = append(v.middleware, middleware...)
+	v.publishChains(true, nil, nil)
+}
+
+// UseVhost appends middleware to the chain of the vhost with the given hostname or alias
+func (v *Vhosts) UseVhost(hostname string, middleware ...FiberHandler) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	i, ok := v.index[indexKey(hostname)]
+	if !ok {
+		return errors.New("vhost not found")
+	}
+	if v.vhostMiddleware == nil {
+		v.vhostMiddleware = make(map[string][]FiberHandler)
+	}
+	key := vhostKey(v.Vhosts[i])
+	v.vhostMiddleware[key] = append(v.vhostMiddleware[key], middleware...)
+	v.publishChains(false, []string{key}, nil)
+	return nil
+}
+
+// UseHandler appends middleware to the chain of the given handler tag ( string ), it runs for every vhost using the tag as path
+func (v *Vhosts) UseHandler(handlerTag string, middleware ...FiberHandler) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if v.handlerMiddleware == nil {
+		v.handlerMiddleware = make(map[string][]FiberHandler)
+	}
+	v.handlerMiddleware[handlerTag] = append(v.handlerMiddleware[handlerTag], middleware...)
+	v.publishChains(false, nil, []string{handlerTag})
+	return nil
+}
+
+// serve calls the handler of the vhost ( or the app mounted on it ) behind its middleware chain. The chain
+// runs the global middleware first, then the middleware of the vhost and then the middleware of its handler tag.
+// The handler always gets the ctx of the caller, so c.Route(), c.Params() and c.Next() behave the same with and
+// without middleware.
+func (v *Vhosts) serve(c *fiber.Ctx, vhost Vhost) error {
+	handler := vhost.Handler
+	if mounted, ok := v.mountedHandler(vhost); ok {
+		handler = mounted
+	}
+
+	dispatcher := v.dispatcher(vhost)
+	if dispatcher == nil {
+		return handler(c)
+	}
+
+	c.Locals(terminalKey{}, func() error {
+		return handler(c)
+	})
+	dispatcher.handler(c.Context())
+	err, _ := c.Locals(chainErrorKey{}).(error)
+	c.Locals(chainErrorKey{}, nil)
+	return err
+}
+
+// dispatcher returns the middleware chain of the vhost, or nil if it has no middleware.
+// The chains are looked up in the routing table so the request path never takes the lock.
+func (v *Vhosts) dispatcher(vhost Vhost) *middlewareChain {
+	chains := v.routing().chains
+	if len(chains) == 0 {
+		return nil
+	}
+	key := vhostKey(vhost)
+	for _, chain := range []string{key + "\x00" + vhost.Path, key + "\x00", "\x00" + vhost.Path, "\x00"} {
+		if dispatcher, ok := chains[chain]; ok {
+			return dispatcher
+		}
+	}
+	return nil
+}
+
+// publishChains compiles the middleware chains affected by a change and publishes them in the routing table, the
+// caller must hold the write lock. There is a chain for the global middleware, one per handler tag, one per vhost
+// with middleware and one per vhost with middleware and handler tag, keyed by vhost key and handler tag ( empty
+// for any ). The published chains are copied and only the chains of the given vhost keys and handler tags are
+// compiled again, all chains only if all is set ( the global middleware changed ).
+func (v *Vhosts) publishChains(all bool, vhostKeys []string, tags []string) {
+	chains := make(map[string]*middlewareChain)
+	if !all {
+		maps.Copy(chains, v.published().chains)
+	}
+	set := func(key string, middleware ...[]FiberHandler) {
+		if chain := slices.Concat(middleware...); len(chain) > 0 {
+			chains[key] = newChain(chain)
+		} else {
+			delete(chains, key)
+		}
+	}
+	compileVhost := func(key string) {
+		vhostMiddleware, ok := v.vhostMiddleware[key]
+		if !ok {
+			// the vhost lost its middleware, its requests fall back to the global and tag chains
+			delete(chains, key+"\x00")
+			for tag := range v.handlerMiddleware {
+				delete(chains, key+"\x00"+tag)
+			}
+			return
+		}
+		set(key+"\x00", v.middleware, vhostMiddleware)
+		for tag, tagMiddleware := range v.handlerMiddleware {
+			set(key+"\x00"+tag, v.middleware, vhostMiddleware, tagMiddleware)
+		}
+	}
+	compileTag := func(tag string) {
+		set("\x00"+tag, v.middleware, v.handlerMiddleware[tag])
+		for key, vhostMiddleware := range v.vhostMiddleware {
+			set(key+"\x00"+tag, v.middleware, vhostMiddleware, v.handlerMiddleware[tag])
+		}
+	}
+
+	if all {
+		set("\x00", v.middleware)
+		for tag, tagMiddleware := range v.handlerMiddleware {
+			set("\x00"+tag, v.middleware, tagMiddleware)
+		}
+		for key := range v.vhostMiddleware {
+			compileVhost(key)
+		}
+	}
+	for _, key := range vhostKeys {
+		compileVhost(key)
+	}
+	for _, tag := range tags {
+		compileTag(tag)
+	}
+	v.publish(func(table *routingTable) {
+		table.chains = chains
+	})
+}
+
+// middlewareChain is a compiled middleware chain, chains are shared by the routing tables until they are compiled again
+type middlewareChain struct {
+	handler fasthttp.RequestHandler
+}
+
+// newChain returns a middleware chain running the middleware in order and the terminal step stored in the locals.
+// The chain is a small fiber app so c.Next() inside the middleware moves along the chain as usual,
+// errors are handed back to serve through the locals instead of being handled by the chain.
+// The terminal step runs the handler on the ctx of the caller, c.Next() in the handler continues in the app XVhost
+// is registered on instead of ending the chain.
+func newChain(middleware []FiberHandler) *middlewareChain {
+	app := fiber.New(fiber.Config{
+		DisableStartupMessage: true,
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			c.Locals(chainErrorKey{}, err)
+			return nil
+		},
+	})
+	for _, handler := range middleware {
+		app.Use(fiber.Handler(handler))
+	}
+	app.Use(func(c *fiber.Ctx) error {
+		terminal, _ := c.Locals(terminalKey{}).(func() error)
+		if terminal == nil {
+			return fiber.ErrNotFound
+		}
+		return terminal()
+	})
+	return &middlewareChain{handler: app.Handler()}
+}
+
+// vhostKey returns the key identifying the vhost in the middleware chains, its primary hostname with its first port
+func vhostKey(vhost Vhost) string {
+	entry := vhostEntries(vhost)[0]
+	return entry.host + entry.suffixes[0]
+}
@@ -0,0 +1,198 @@
+package vhosts
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVhosts_MiddlewareChain(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	assert.Nil(t, vhosts.Add(NewVhost("a.com", "site", "", func(c *fiber.Ctx) error {
+		return c.SendString(c.Locals("trace").(string) + "handler")
+	}, mockErrorHandler)))
+	assert.Nil(t, vhosts.Add(NewVhost("b.com", "", "", func(c *fiber.Ctx) error {
+		return c.SendString(c.Locals("trace").(string) + "handler")
+	}, mockErrorHandler)))
+
+	// record the order the middleware runs in
+	trace := func(name string) FiberHandler {
+		return func(c *fiber.Ctx) error {
+			previous, _ := c.Locals("trace").(string)
+			c.Locals("trace", previous+name+" ")
+			err := c.Next()
+			c.Append("X-Trace", name)
+			return err
+		}
+	}
+	vhosts.Use(trace("global"))
+	assert.Nil(t, vhosts.UseVhost("a.com", trace("vhost")))
+	assert.Nil(t, vhosts.UseHandler("site", trace("tag")))
+	assert.NotNil(t, vhosts.UseVhost("missing.com", trace("vhost")))
+
+	app := fiber.New()
+	app.Use(XVhost(vhosts))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "http://a.com/", nil))
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "global vhost tag handler", string(body))
+	// the code after c.Next() runs when the chain unwinds
+	assert.Equal(t, "tag, vhost, global", resp.Header.Get("X-Trace"))
+
+	resp, err = app.Test(httptest.NewRequest("GET", "http://b.com/", nil))
+	assert.Nil(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, "global handler", string(body))
+}
+
+func TestVhosts_MiddlewareChain_ShortCircuit(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.Add(NewVhost("a.com", "", "", mockMiddleware, nil))
+	vhosts.UseVhost("a.com", func(c *fiber.Ctx) error {
+		if c.Get("Authorization") == "" {
+			return c.SendStatus(fiber.StatusUnauthorized)
+		}
+		return c.Next()
+	}, func(c *fiber.Ctx) error {
+		if c.Path() == "/fail" {
+			return errors.New("chain failed")
+		}
+		return c.Next()
+	})
+
+	app := fiber.New(fiber.Config{
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			return c.Status(500).SendString(err.Error())
+		},
+	})
+	app.Use(XVhost(vhosts))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "http://a.com/", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+
+	req := httptest.NewRequest("GET", "http://a.com/", nil)
+	req.Header.Set("Authorization", "yes")
+	resp, err = app.Test(req)
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "Hello, World!", string(body))
+
+	// errors returned inside the chain come back out of the middleware
+	req = httptest.NewRequest("GET", "http://a.com/fail", nil)
+	req.Header.Set("Authorization", "yes")
+	resp, err = app.Test(req)
+	assert.Nil(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, "chain failed", string(body))
+
+	// the chain of a removed vhost is dropped
+	assert.Nil(t, vhosts.Remove("a.com"))
+	vhosts.Add(NewVhost("a.com", "", "", mockMiddleware, mockErrorHandler))
+	resp, err = app.Test(httptest.NewRequest("GET", "http://a.com/", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestVhosts_MiddlewareChain_LockFree(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	assert.Nil(t, vhosts.Add(NewVhost("a.com", "", "", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.UseVhost("a.com", func(c *fiber.Ctx) error {
+		return c.Next()
+	}))
+	app := fiber.New()
+	app.Use(XVhost(vhosts))
+
+	// requests are served while a writer holds the lock
+	vhosts.mutex.Lock()
+	defer vhosts.mutex.Unlock()
+	resp, err := app.Test(httptest.NewRequest("GET", "http://a.com/", nil), 1000)
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "Hello, World!", string(body))
+}
+
+func TestVhosts_MiddlewareChain_Incremental(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	assert.Nil(t, vhosts.Add(NewVhost("a.com", "site", "", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.Add(NewVhost("b.com", "site", "", mockMiddleware, mockErrorHandler)))
+	next := func(c *fiber.Ctx) error { return c.Next() }
+	assert.Nil(t, vhosts.UseVhost("a.com", next))
+	assert.Nil(t, vhosts.UseHandler("site", next))
+
+	// chain returns the identity of the published chain with the given key
+	chain := func(key string) *middlewareChain {
+		return vhosts.routing().chains[key]
+	}
+	a, aTag := chain("a.com\x00"), chain("a.com\x00site")
+	assert.NotNil(t, a)
+	assert.NotNil(t, aTag)
+
+	// the middleware of another vhost leaves the chains of a.com alone
+	assert.Nil(t, vhosts.UseVhost("b.com", next))
+	assert.Same(t, a, chain("a.com\x00"))
+	assert.Same(t, aTag, chain("a.com\x00site"))
+	assert.NotNil(t, chain("b.com\x00site"))
+
+	// a tag recompiles the chains using it only
+	assert.Nil(t, vhosts.UseHandler("other", next))
+	assert.Same(t, a, chain("a.com\x00"))
+	assert.Same(t, aTag, chain("a.com\x00site"))
+	assert.NotNil(t, chain("a.com\x00other"))
+
+	// removing a vhost drops its chains only
+	assert.Nil(t, vhosts.Remove("b.com"))
+	assert.Nil(t, chain("b.com\x00"))
+	assert.Nil(t, chain("b.com\x00site"))
+	assert.Same(t, a, chain("a.com\x00"))
+
+	// the global middleware is part of every chain
+	vhosts.Use(next)
+	assert.NotSame(t, a, chain("a.com\x00"))
+	assert.NotNil(t, chain("\x00"))
+}
+
+func TestVhosts_MiddlewareChain_Next(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	// the handler hands the request on to the routes of the app
+	handler := func(c *fiber.Ctx) error {
+		c.Set("X-Vhost", "passed")
+		return c.Next()
+	}
+	assert.Nil(t, vhosts.Add(NewVhost("a.com", "", "", handler, mockErrorHandler)))
+	assert.Nil(t, vhosts.Add(NewVhost("b.com", "", "", handler, mockErrorHandler)))
+	assert.Nil(t, vhosts.UseVhost("a.com", func(c *fiber.Ctx) error {
+		err := c.Next()
+		c.Append("X-Trace", "middleware")
+		return err
+	}))
+
+	app := fiber.New()
+	app.Get("/users/:id", XVhost(vhosts), func(c *fiber.Ctx) error {
+		return c.SendString(c.Route().Path + " " + c.Params("id"))
+	})
+
+	// with and without middleware the handler continues in the app
+	for _, host := range []string{"a.com", "b.com"} {
+		resp, err := app.Test(httptest.NewRequest("GET", "http://"+host+"/users/42", nil))
+		assert.Nil(t, err, host)
+		assert.Equal(t, 200, resp.StatusCode, host)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "/users/:id 42", string(body), host)
+		assert.Equal(t, "passed", resp.Header.Get("X-Vhost"), host)
+	}
+
+	// the middleware still wraps the handler
+	resp, err := app.Test(httptest.NewRequest("GET", "http://a.com/users/42", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, "middleware", resp.Header.Get("X-Trace"))
+}
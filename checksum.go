@@ -0,0 +1,193 @@
+package vhosts
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+)
+
+// ChecksumVersion is the version of the checksum scheme written by Save. Version 1 is the legacy raw sha256
+// of the hostnames and websiteIDs ( see Hash ), version 2 covers every persisted field and is stored in hex.
+const ChecksumVersion = 2
+
+// checksumPrefix prefixes the checksums of the current scheme
+const checksumPrefix = "v2:"
+
+// ErrChecksumMismatch is matched by errors.Is for every *ChecksumError
+var ErrChecksumMismatch = errors.New("vhosts list checksum doesn't match")
+
+// ChecksumError is returned by Load when the vhosts list doesn't match its checksum
+type ChecksumError struct {
+	Report *VerifyReport // report describes which entries differ
+}
+
+// Error implements the error interface
+func (e *ChecksumError) Error() string {
+	return ErrChecksumMismatch.Error()
+}
+
+// Is reports whether target is ErrChecksumMismatch
+func (e *ChecksumError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
+
+// VerifyReport describes the result of verifying a vhosts list against its checksums
+type VerifyReport struct {
+	Scheme  int             // scheme is the version of the checksum scheme of the file
+	Valid   bool            // valid reports whether the vhosts list matches its checksum
+	List    bool            // list reports whether the list checksum ( entries, order, version and last modified time ) differs
+	Entries []EntryMismatch // entries lists the entries that differ, only for scheme 2
+}
+
+// EntryMismatch describes a vhost that doesn't match its checksum
+type EntryMismatch struct {
+	Key      string // key is the normalized hostname ( and port ) identifying the entry
+	Hostname string // hostname is the hostname of the vhost, empty if the vhost is missing
+	Reason   string // reason is "modified", "added" ( no checksum for the entry ) or "removed" ( checksum without entry )
+	Expected string // expected is the stored checksum of the entry
+	Actual   string // actual is the checksum of the entry as loaded
+}
+
+// String describes the mismatch
+func (m EntryMismatch) String() string {
+	return fmt.Sprintf("%s %s", m.Key, m.Reason)
+}
+
+// checksumEncoder writes fields to a hash with an unambiguous encoding, every field is tagged with its length
+type checksumEncoder struct {
+	h hash.Hash
+}
+
+func (e checksumEncoder) string(s string) {
+	e.int(int64(len(s)))
+	e.h.Write([]byte(s))
+}
+
+func (e checksumEncoder) int(i int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(i))
+	e.h.Write(b[:])
+}
+
+func (e checksumEncoder) bool(b bool) {
+	if b {
+		e.int(1)
+	} else {
+		e.int(0)
+	}
+}
+
+func (e checksumEncoder) sum() string {
+	return checksumPrefix + hex.EncodeToString(e.h.Sum(nil))
+}
+
+// checksumVhost returns the checksum of every persisted field of the vhost
+func checksumVhost(vhost Vhost) string {
+	e := checksumEncoder{h: sha256.New()}
+	e.string("vhost")
+	e.string(vhost.Hostname)
+	e.string(vhost.Path)
+	e.string(vhost.WebsiteID)
+	e.int(vhost.LastModified)
+	e.int(int64(len(vhost.Ports)))
+	for _, port := range vhost.Ports {
+		e.int(int64(port))
+	}
+	e.int(int64(len(vhost.Aliases)))
+	for _, alias := range vhost.Aliases {
+		e.string(alias)
+	}
+	e.int(int64(vhost.Canonical))
+	e.int(int64(vhost.RedirectStatus))
+	e.int(int64(len(vhost.Routes)))
+	for _, route := range vhost.Routes {
+		e.string(route.Prefix)
+		e.string(route.HandlerTag)
+		e.bool(route.StripPrefix)
+	}
+	return e.sum()
+}
+
+// checksumVhosts returns the checksum of the vhosts list and the checksums of its entries by key.
+// The list checksum covers the entries in order, the order decides which template matches first.
+func checksumVhosts(vhosts []Vhost, lastModified, version int64) (string, map[string]string) {
+	entries := make(map[string]string, len(vhosts))
+	e := checksumEncoder{h: sha256.New()}
+	e.string("vhosts")
+	e.int(lastModified)
+	e.int(version)
+	e.int(int64(len(vhosts)))
+	for _, vhost := range vhosts {
+		checksum := checksumVhost(vhost)
+		entries[vhostKey(vhost)] = checksum
+		e.string(checksum)
+	}
+	return e.sum(), entries
+}
+
+// updateChecksums sets the checksums of the vhosts list, the caller must hold the write lock
+func (v *Vhosts) updateChecksums() {
+	v.Checksum, v.Checksums = checksumVhosts(v.Vhosts, v.LastModified, v.Version)
+}
+
+// verifyChecksums verifies the vhosts list decoded from a file of the given format version against its checksums.
+// The legacy checksum is only accepted for format version 1, later versions always carry a version 2 checksum.
+func verifyChecksums(v *Vhosts, format int) (*VerifyReport, error) {
+	if !strings.HasPrefix(v.Checksum, checksumPrefix) {
+		if format >= 2 {
+			return &VerifyReport{Scheme: 1, List: true}, nil
+		}
+		// legacy checksum
+		hash, err := Hash(v.Vhosts)
+		if err != nil {
+			return nil, err
+		}
+		return &VerifyReport{Scheme: 1, Valid: hash == v.Checksum, List: hash != v.Checksum}, nil
+	}
+
+	report := &VerifyReport{Scheme: ChecksumVersion}
+	checksum, entries := checksumVhosts(v.Vhosts, v.LastModified, v.Version)
+	seen := make(map[string]bool, len(entries))
+	for _, vhost := range v.Vhosts {
+		key := vhostKey(vhost)
+		seen[key] = true
+		expected, ok := v.Checksums[key]
+		switch {
+		case !ok:
+			report.Entries = append(report.Entries, EntryMismatch{Key: key, Hostname: vhost.Hostname, Reason: "added", Actual: entries[key]})
+		case expected != entries[key]:
+			report.Entries = append(report.Entries, EntryMismatch{Key: key, Hostname: vhost.Hostname, Reason: "modified", Expected: expected, Actual: entries[key]})
+		}
+	}
+	var removed []string
+	for key := range v.Checksums {
+		if !seen[key] {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+	for _, key := range removed {
+		report.Entries = append(report.Entries, EntryMismatch{Key: key, Reason: "removed", Expected: v.Checksums[key]})
+	}
+	report.List = checksum != v.Checksum
+	report.Valid = !report.List && len(report.Entries) == 0
+	return report, nil
+}
+
+// VerifyFile decodes the given file and verifies it against its checksums without loading it
+func VerifyFile(file string, opts ...FileOption) (*VerifyReport, error) {
+	layout, format, err := decode(file, newFileOptions(file, opts))
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := layout.vhosts()
+	if err != nil {
+		return nil, err
+	}
+	return verifyChecksums(decoded, format)
+}
@@ -0,0 +1,143 @@
+package vhosts
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksum_Format(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vhosts.bin")
+	vhosts := newPersistedVhosts(t)
+	assert.Nil(t, vhosts.Save(file))
+
+	assert.True(t, strings.HasPrefix(vhosts.Checksum, "v2:"))
+	assert.Len(t, vhosts.Checksum, len("v2:")+64)
+	assert.Len(t, vhosts.Checksums, 3)
+	assert.Equal(t, checksumVhost(vhosts.Vhosts[1]), vhosts.Checksums["*.example.org:8080"])
+
+	report, err := VerifyFile(file)
+	assert.Nil(t, err)
+	assert.Equal(t, &VerifyReport{Scheme: ChecksumVersion, Valid: true}, report)
+}
+
+func TestChecksum_CoversEveryField(t *testing.T) {
+	base := NewVhost("example.com", "shop", "1", nil, nil)
+	base.Routes = []PathRoute{{Prefix: "/blog", HandlerTag: "blog"}}
+	changes := map[string]func(vhost *Vhost){
+		"hostname":       func(vhost *Vhost) { vhost.Hostname = "example.net" },
+		"path":           func(vhost *Vhost) { vhost.Path = "admin" },
+		"websiteID":      func(vhost *Vhost) { vhost.WebsiteID = "2" },
+		"lastModified":   func(vhost *Vhost) { vhost.LastModified++ },
+		"ports":          func(vhost *Vhost) { vhost.Ports = []int{8080} },
+		"aliases":        func(vhost *Vhost) { vhost.Aliases = []string{"www.example.com"} },
+		"canonical":      func(vhost *Vhost) { vhost.Canonical = CanonicalWWW },
+		"redirectStatus": func(vhost *Vhost) { vhost.RedirectStatus = 308 },
+		"route prefix":   func(vhost *Vhost) { vhost.Routes = []PathRoute{{Prefix: "/news", HandlerTag: "blog"}} },
+		"route tag":      func(vhost *Vhost) { vhost.Routes = []PathRoute{{Prefix: "/blog", HandlerTag: "admin"}} },
+		"route strip": func(vhost *Vhost) {
+			vhost.Routes = []PathRoute{{Prefix: "/blog", HandlerTag: "blog", StripPrefix: true}}
+		},
+		// the encoding is unambiguous, moving bytes from one field to the next changes the checksum
+		"field boundary": func(vhost *Vhost) { vhost.Hostname, vhost.Path = "example.coms", "hop" },
+	}
+	for name, change := range changes {
+		vhost := base
+		change(&vhost)
+		assert.NotEqual(t, checksumVhost(base), checksumVhost(vhost), name)
+	}
+
+	// the list checksum covers the order, the version and the last modified time
+	list := []Vhost{base, NewVhost("example.org", "", "2", nil, nil)}
+	checksum, _ := checksumVhosts(list, 1, 1)
+	reordered, _ := checksumVhosts([]Vhost{list[1], list[0]}, 1, 1)
+	assert.NotEqual(t, checksum, reordered)
+	bumped, _ := checksumVhosts(list, 1, 2)
+	assert.NotEqual(t, checksum, bumped)
+	touched, _ := checksumVhosts(list, 2, 1)
+	assert.NotEqual(t, checksum, touched)
+}
+
+func TestChecksum_Report(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vhosts.yaml")
+	vhosts := newPersistedVhosts(t)
+	assert.Nil(t, vhosts.Save(file))
+
+	// re-point the handler of a vhost and drop another one, keeping the stored checksums
+	layout, _, err := decode(file, newFileOptions(file, nil))
+	assert.Nil(t, err)
+	tampered, err := layout.vhosts()
+	assert.Nil(t, err)
+	tampered.Vhosts[0].Path = "admin"
+	tampered.Vhosts = tampered.Vhosts[:2]
+	assert.Nil(t, encodeFile(file, tampered, fileOptions{codec: YAMLCodec}))
+
+	err = (&Vhosts{}).Load(file)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+	assert.EqualError(t, err, "vhosts list checksum doesn't match")
+	var checksumErr *ChecksumError
+	assert.True(t, errors.As(err, &checksumErr))
+	report := checksumErr.Report
+	assert.False(t, report.Valid)
+	assert.True(t, report.List)
+	assert.Len(t, report.Entries, 2)
+	assert.Equal(t, "example.com modified", report.Entries[0].String())
+	assert.Equal(t, "example.com", report.Entries[0].Hostname)
+	assert.Equal(t, vhosts.Checksums["example.com"], report.Entries[0].Expected)
+	assert.Equal(t, "{tenant}.example.io removed", report.Entries[1].String())
+
+	verified, err := VerifyFile(file)
+	assert.Nil(t, err)
+	assert.Equal(t, report, verified)
+}
+
+func TestChecksum_ListOnly(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vhosts.json")
+	vhosts := newPersistedVhosts(t)
+	assert.Nil(t, vhosts.Save(file))
+
+	// the entries are intact but the version was changed
+	data, err := os.ReadFile(file)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(file, []byte(strings.Replace(string(data), `"version": 4`, `"version": 9`, 1)), 0644))
+
+	report, err := VerifyFile(file)
+	assert.Nil(t, err)
+	assert.False(t, report.Valid)
+	assert.True(t, report.List)
+	assert.Empty(t, report.Entries)
+}
+
+func TestChecksum_Legacy(t *testing.T) {
+	// files of format version 1 written with the legacy checksum still load
+	for _, name := range []string{"v1-baseline.bin", "v1-legacy-checksum.bin", "v1-legacy-checksum.json"} {
+		file := filepath.Join("testdata", name)
+		report, err := VerifyFile(file)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, report.Scheme, name)
+		assert.True(t, report.Valid, name)
+		assert.Nil(t, (&Vhosts{}).Load(file), name)
+	}
+
+	// later format versions refuse it, it doesn't cover most of the fields
+	dir := t.TempDir()
+	vhosts := newPersistedVhosts(t)
+	vhosts.Vhosts[0].Path = "admin"
+	hash, err := Hash(vhosts.Vhosts)
+	assert.Nil(t, err)
+	vhosts.Checksum = hash
+	for _, name := range []string{"vhosts.bin", "vhosts.json"} {
+		file := filepath.Join(dir, name)
+		assert.Nil(t, encodeFile(file, vhosts, newFileOptions(file, nil)))
+		assert.ErrorIs(t, (&Vhosts{}).Load(file), ErrChecksumMismatch, name)
+
+		report, err := VerifyFile(file)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, report.Scheme)
+		assert.False(t, report.Valid)
+	}
+}
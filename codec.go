@@ -0,0 +1,344 @@
+package vhosts
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Codec encodes and decodes the vhosts list for Save and Load. Handlers and error handlers are never
+// persisted, they are linked again by ReloadHandlers.
+type Codec interface {
+	// Encode writes the vhosts list to w
+	Encode(w io.Writer, v *Vhosts) error
+	// Decode reads the vhosts list from r into v
+	Decode(r io.Reader, v *Vhosts) error
+}
+
+var (
+	// GobCodec persists the vhosts list as gob ( default )
+	GobCodec Codec = gobCodec{}
+	// JSONCodec persists the vhosts list as indented JSON
+	JSONCodec Codec = jsonCodec{}
+	// YAMLCodec persists the vhosts list as YAML
+	YAMLCodec Codec = yamlCodec{}
+)
+
+// CodecForFile returns the codec for the extension of the given file, .json for JSON, .yaml or .yml for YAML and gob otherwise
+func CodecForFile(file string) Codec {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		return JSONCodec
+	case ".yaml", ".yml":
+		return YAMLCodec
+	default:
+		return GobCodec
+	}
+}
+
+// FileOption configures how Save and Load persist the vhosts list
+type FileOption func(o *fileOptions)
+
+// fileOptions holds the options of Save and Load
+type fileOptions struct {
+	codec   Codec
+	backups int
+	signer  Signer
+	trusted []Verifier
+	keyID   string
+	keys    KeyFunc
+}
+
+// WithCodec persists the vhosts list with the given codec instead of the codec for the file extension
+func WithCodec(codec Codec) FileOption {
+	return func(o *fileOptions) {
+		o.codec = codec
+	}
+}
+
+// newFileOptions applies the options for the given file
+func newFileOptions(file string, opts []FileOption) fileOptions {
+	o := fileOptions{codec: CodecForFile(file)}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// encodeFile encodes the vhosts list with the codec, encrypts and signs it and atomically replaces the given file with it
+func encodeFile(file string, v *Vhosts, o fileOptions) error {
+	var payload bytes.Buffer
+	if err := o.codec.Encode(&payload, v); err != nil {
+		return err
+	}
+	data, err := o.seal(payload.Bytes())
+	if err != nil {
+		return err
+	}
+	data, err = o.sign(data)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(file, o.backups, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// layoutCodec is implemented by the built-in codecs, they store the format version with the vhosts list and
+// decode the layouts of every format version ( see migrations )
+type layoutCodec interface {
+	Codec
+	// format returns the format version of the payload
+	format(payload []byte) (int, error)
+	// decodeLayout decodes the payload into the layout of its format version
+	decodeLayout(payload []byte, layout fileLayout) error
+}
+
+// decodePayload decodes the payload into the layout of its format version and returns the format version
+func decodePayload(codec layoutCodec, payload []byte) (fileLayout, int, error) {
+	version, err := codec.format(payload)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := checkFormat(version); err != nil {
+		return nil, 0, err
+	}
+	layout := layoutOf(version)
+	if err := codec.decodeLayout(payload, layout); err != nil {
+		return nil, 0, err
+	}
+	return layout, version, nil
+}
+
+// decodeVhosts decodes the payload read from r, migrates it to the current format version and sets the vhosts list of v
+func decodeVhosts(codec layoutCodec, r io.Reader, v *Vhosts) error {
+	payload, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	layout, version, err := decodePayload(codec, payload)
+	if err != nil {
+		return err
+	}
+	layout, err = migrate(layout, version)
+	if err != nil {
+		return err
+	}
+	decoded, err := layout.vhosts()
+	if err != nil {
+		return err
+	}
+	v.Vhosts = decoded.Vhosts
+	v.LastModified = decoded.LastModified
+	v.Version = decoded.Version
+	v.Checksum = decoded.Checksum
+	v.Checksums = decoded.Checksums
+	return nil
+}
+
+// gobCodec is the codec behind GobCodec, gob has no room for a version next to the vhosts list so the
+// format version is written in a header line in front of it
+type gobCodec struct{}
+
+func (gobCodec) Encode(w io.Writer, v *Vhosts) error {
+	if _, err := w.Write(formatHeader()); err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(newVhostsFile(v))
+}
+
+func (c gobCodec) Decode(r io.Reader, v *Vhosts) error {
+	return decodeVhosts(c, r, v)
+}
+
+func (gobCodec) format(payload []byte) (int, error) {
+	version, _, err := readFormat(payload)
+	return version, err
+}
+
+func (gobCodec) decodeLayout(payload []byte, layout fileLayout) error {
+	_, payload, err := readFormat(payload)
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(payload)).Decode(layout)
+}
+
+// jsonCodec is the codec behind JSONCodec
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(w io.Writer, v *Vhosts) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(newVhostsFile(v))
+}
+
+func (c jsonCodec) Decode(r io.Reader, v *Vhosts) error {
+	return decodeVhosts(c, r, v)
+}
+
+func (jsonCodec) format(payload []byte) (int, error) {
+	var header formatField
+	if err := json.Unmarshal(payload, &header); err != nil {
+		return 0, err
+	}
+	return header.version(), nil
+}
+
+func (jsonCodec) decodeLayout(payload []byte, layout fileLayout) error {
+	if err := json.Unmarshal(payload, layout); err != nil {
+		return err
+	}
+	return unhexChecksum(layout)
+}
+
+// yamlCodec is the codec behind YAMLCodec
+type yamlCodec struct{}
+
+func (yamlCodec) Encode(w io.Writer, v *Vhosts) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(newVhostsFile(v)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func (c yamlCodec) Decode(r io.Reader, v *Vhosts) error {
+	return decodeVhosts(c, r, v)
+}
+
+func (yamlCodec) format(payload []byte) (int, error) {
+	var header formatField
+	if err := yaml.Unmarshal(payload, &header); err != nil {
+		return 0, err
+	}
+	return header.version(), nil
+}
+
+func (yamlCodec) decodeLayout(payload []byte, layout fileLayout) error {
+	if err := yaml.Unmarshal(payload, layout); err != nil {
+		return err
+	}
+	return unhexChecksum(layout)
+}
+
+// formatField is the format version field of the text formats, files of version 1 don't have it
+type formatField struct {
+	Format int `json:"format" yaml:"format"`
+}
+
+// version returns the format version
+func (f formatField) version() int {
+	if f.Format == 0 {
+		return 1
+	}
+	return f.Format
+}
+
+// unhexChecksum decodes the legacy checksum of a version 1 layout, the text formats of version 1 hex encode it
+func unhexChecksum(layout fileLayout) error {
+	v1, ok := layout.(*vhostsFileV1)
+	if !ok || strings.HasPrefix(v1.Checksum, checksumPrefix) {
+		return nil
+	}
+	raw, err := hex.DecodeString(v1.Checksum)
+	if err != nil {
+		return errors.New("invalid checksum encoding")
+	}
+	v1.Checksum = string(raw)
+	return nil
+}
+
+// vhostsFile is the layout of the vhosts list in the files of the current format version, every codec persists it
+type vhostsFile struct {
+	Format       int               `json:"format" yaml:"format"`
+	Vhosts       []vhostFile       `json:"vhosts" yaml:"vhosts"`
+	LastModified int64             `json:"lastModified" yaml:"lastModified"`
+	Version      int64             `json:"version" yaml:"version"`
+	Checksum     string            `json:"checksum" yaml:"checksum"`
+	Checksums    map[string]string `json:"checksums,omitempty" yaml:"checksums,omitempty"`
+}
+
+// vhostFile is the layout of a vhost in the files of the current format version
+type vhostFile struct {
+	Hostname       string          `json:"hostname" yaml:"hostname"`
+	Path           string          `json:"path,omitempty" yaml:"path,omitempty"`
+	WebsiteID      string          `json:"websiteID,omitempty" yaml:"websiteID,omitempty"`
+	LastModified   int64           `json:"lastModified,omitempty" yaml:"lastModified,omitempty"`
+	Ports          []int           `json:"ports,omitempty" yaml:"ports,omitempty,flow"`
+	Aliases        []string        `json:"aliases,omitempty" yaml:"aliases,omitempty"`
+	Canonical      CanonicalPolicy `json:"canonical,omitempty" yaml:"canonical,omitempty"`
+	RedirectStatus int             `json:"redirectStatus,omitempty" yaml:"redirectStatus,omitempty"`
+	Routes         []routeFile     `json:"routes,omitempty" yaml:"routes,omitempty"`
+}
+
+// routeFile is the layout of a path route in the files of the current format version
+type routeFile struct {
+	Prefix      string `json:"prefix" yaml:"prefix"`
+	HandlerTag  string `json:"handlerTag" yaml:"handlerTag"`
+	StripPrefix bool   `json:"stripPrefix,omitempty" yaml:"stripPrefix,omitempty"`
+}
+
+// newVhostsFile returns the layout of the vhosts list in the current format version
+func newVhostsFile(v *Vhosts) vhostsFile {
+	file := vhostsFile{
+		Format:       FormatVersion,
+		LastModified: v.LastModified,
+		Version:      v.Version,
+		Checksum:     v.Checksum,
+		Checksums:    v.Checksums,
+	}
+	for _, vhost := range v.Vhosts {
+		entry := vhostFile{
+			Hostname:       vhost.Hostname,
+			Path:           vhost.Path,
+			WebsiteID:      vhost.WebsiteID,
+			LastModified:   vhost.LastModified,
+			Ports:          vhost.Ports,
+			Aliases:        vhost.Aliases,
+			Canonical:      vhost.Canonical,
+			RedirectStatus: vhost.RedirectStatus,
+		}
+		for _, route := range vhost.Routes {
+			entry.Routes = append(entry.Routes, routeFile(route))
+		}
+		file.Vhosts = append(file.Vhosts, entry)
+	}
+	return file
+}
+
+// vhosts returns the vhosts list stored in the layout
+func (f *vhostsFile) vhosts() (*Vhosts, error) {
+	v := &Vhosts{
+		LastModified: f.LastModified,
+		Version:      f.Version,
+		Checksum:     f.Checksum,
+		Checksums:    f.Checksums,
+	}
+	for _, entry := range f.Vhosts {
+		vhost := Vhost{
+			Hostname:       entry.Hostname,
+			Path:           entry.Path,
+			WebsiteID:      entry.WebsiteID,
+			LastModified:   entry.LastModified,
+			Ports:          entry.Ports,
+			Aliases:        entry.Aliases,
+			Canonical:      entry.Canonical,
+			RedirectStatus: entry.RedirectStatus,
+		}
+		for _, route := range entry.Routes {
+			vhost.Routes = append(vhost.Routes, PathRoute(route))
+		}
+		v.Vhosts = append(v.Vhosts, vhost)
+	}
+	return v, nil
+}
@@ -0,0 +1,142 @@
+package vhosts
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newPersistedVhosts returns a vhosts list using every persisted field
+func newPersistedVhosts(t *testing.T) *Vhosts {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	assert.Nil(t, vhosts.AddHandler("blog", mockMiddleware))
+
+	vhost := NewVhost("example.com", "shop", "1", nil, nil)
+	vhost.Aliases = []string{"www.example.com", "example.net"}
+	vhost.Canonical = CanonicalPrimary
+	vhost.RedirectStatus = 308
+	assert.Nil(t, vhosts.Add(vhost))
+	assert.Nil(t, vhosts.AddRoute("example.com", "/blog", "blog", true))
+
+	vhost = NewVhost("*.example.org", "", "2", nil, nil)
+	vhost.Ports = []int{8080, 8443}
+	assert.Nil(t, vhosts.Add(vhost))
+	assert.Nil(t, vhosts.Add(NewVhost("{tenant}.example.io", "tenant", "3", nil, nil)))
+	return vhosts
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	vhosts := newPersistedVhosts(t)
+
+	// the gob format is the reference
+	assert.Nil(t, vhosts.Save(filepath.Join(dir, "vhosts.bin")))
+	reference := &Vhosts{}
+	assert.Nil(t, reference.Load(filepath.Join(dir, "vhosts.bin")))
+	assert.Equal(t, vhosts.Vhosts, reference.Vhosts)
+
+	for _, name := range []string{"vhosts.json", "vhosts.yaml", "vhosts.yml"} {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(dir, name)
+			assert.Nil(t, vhosts.Save(file))
+			loaded := &Vhosts{}
+			assert.Nil(t, loaded.Load(file))
+			assert.Equal(t, reference.Vhosts, loaded.Vhosts)
+			assert.Equal(t, reference.Version, loaded.Version)
+			assert.Equal(t, reference.LastModified, loaded.LastModified)
+			assert.Equal(t, reference.Checksum, loaded.Checksum)
+
+			// the loaded vhosts are indexed
+			vhost, ok := loaded.Get("www.example.com")
+			assert.True(t, ok)
+			assert.Equal(t, "1", vhost.WebsiteID)
+			vhost, ok = loaded.Get("acme.example.io")
+			assert.True(t, ok)
+			assert.Equal(t, "3", vhost.WebsiteID)
+		})
+	}
+}
+
+func TestCodec_Readable(t *testing.T) {
+	dir := t.TempDir()
+	vhosts := newPersistedVhosts(t)
+
+	assert.Nil(t, vhosts.Save(filepath.Join(dir, "vhosts.yaml")))
+	data, err := os.ReadFile(filepath.Join(dir, "vhosts.yaml"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "- hostname: example.com\n")
+	assert.Contains(t, string(data), "canonical: primary\n")
+	assert.Contains(t, string(data), "ports: [8080, 8443]\n")
+	assert.Contains(t, string(data), "handlerTag: blog\n")
+
+	assert.Nil(t, vhosts.Save(filepath.Join(dir, "vhosts.json")))
+	data, err = os.ReadFile(filepath.Join(dir, "vhosts.json"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"hostname": "example.com"`)
+	assert.Contains(t, string(data), `"canonical": "primary"`)
+}
+
+func TestCodec_WithCodec(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vhosts.data")
+	vhosts := newPersistedVhosts(t)
+
+	assert.Nil(t, vhosts.Save(file, WithCodec(JSONCodec)))
+	data, err := os.ReadFile(file)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "{\n  \"format\": 2,"))
+
+	loaded := &Vhosts{}
+	assert.Error(t, loaded.Load(file))
+	assert.Nil(t, loaded.Load(file, WithCodec(JSONCodec)))
+	assert.Equal(t, vhosts.Vhosts, loaded.Vhosts)
+}
+
+func TestCodec_Tampered(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vhosts.json")
+	vhosts := newPersistedVhosts(t)
+	assert.Nil(t, vhosts.Save(file))
+
+	data, err := os.ReadFile(file)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(file, []byte(strings.Replace(string(data), `"websiteID": "1"`, `"websiteID": "9"`, 1)), 0644))
+	assert.EqualError(t, (&Vhosts{}).Load(file), "vhosts list checksum doesn't match")
+}
+
+func TestCodec_Shrink(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vhosts.yaml")
+	vhosts := newPersistedVhosts(t)
+	assert.Nil(t, vhosts.Save(file))
+
+	// a smaller vhosts list replaces the whole file
+	assert.Nil(t, vhosts.Remove("example.com"))
+	assert.Nil(t, vhosts.Save(file))
+	loaded := &Vhosts{}
+	assert.Nil(t, loaded.Load(file))
+	assert.Len(t, loaded.Vhosts, 2)
+}
+
+func TestCodecForFile(t *testing.T) {
+	assert.Equal(t, JSONCodec, CodecForFile("vhosts.JSON"))
+	assert.Equal(t, YAMLCodec, CodecForFile("/etc/vhosts.yml"))
+	assert.Equal(t, YAMLCodec, CodecForFile("vhosts.yaml"))
+	assert.Equal(t, GobCodec, CodecForFile("vhosts.bin"))
+	assert.Equal(t, GobCodec, CodecForFile("vhosts"))
+}
+
+func TestCanonicalPolicy_Text(t *testing.T) {
+	for _, policy := range []CanonicalPolicy{CanonicalNone, CanonicalPrimary, CanonicalWWW, CanonicalApex} {
+		text, err := policy.MarshalText()
+		assert.Nil(t, err)
+		var decoded CanonicalPolicy
+		assert.Nil(t, decoded.UnmarshalText(text))
+		assert.Equal(t, policy, decoded)
+	}
+	var policy CanonicalPolicy
+	assert.Error(t, policy.UnmarshalText([]byte("sometimes")))
+	_, err := CanonicalPolicy(42).MarshalText()
+	assert.Error(t, err)
+}
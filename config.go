@@ -0,0 +1,147 @@
+package vhosts
+
+import (
+	"net"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// UnknownHostPolicy decides what the vhosts middleware does with requests for hostnames without a vhost
+type UnknownHostPolicy int
+
+const (
+	// UnknownHostNotFound responds with 404 Not Found ( default )
+	UnknownHostNotFound UnknownHostPolicy = iota
+	// UnknownHostDefault serves the request with the vhost of Config.DefaultHostname
+	UnknownHostDefault
+	// UnknownHostNext falls through to the rest of the app with c.Next()
+	UnknownHostNext
+	// UnknownHostHandler renders the response with Config.NotFoundHandler ( a "domain not configured" page ), it
+	// behaves like UnknownHostNotFound which uses the handler whenever it is set
+	UnknownHostHandler
+	// UnknownHostClose closes the connection without sending a response
+	UnknownHostClose
+)
+
+// HostExtractor returns the hostname ( with optional port ) of the request used to look up the vhost
+type HostExtractor func(c *fiber.Ctx) string
+
+// Config defines the config for the vhosts middleware
+type Config struct {
+	// Next defines a function to skip this middleware when returned true.
+	//
+	// Optional. Default: nil
+	Next func(c *fiber.Ctx) bool
+
+	// Registry is the vhosts list the middleware serves
+	//
+	// Optional. Default: the package level vhosts list ( see GetVhosts ), without either every hostname is unknown
+	Registry *Vhosts
+
+	// HostExtractor returns the hostname of the request, use TrustedProxyHostExtractor
+	// behind a load balancer to honor forwarded headers from trusted peers only
+	//
+	// Optional. Default: c.Hostname()
+	HostExtractor HostExtractor
+
+	// LocalsPrefix is the prefix of the keys the middleware stores in c.Locals
+	//
+	// Optional. Default: "vhost."
+	LocalsPrefix string
+
+	// ErrorHandler handles the errors returned for vhosts without an error handler
+	// when the registry has no default error handler either
+	//
+	// Optional. Default: nil ( the error is returned to fiber )
+	ErrorHandler fiber.ErrorHandler
+
+	// Recover catches panics of the vhost handlers and routes them as *PanicError to the error handler of the vhost
+	//
+	// Optional. Default: false
+	Recover bool
+
+	// QuarantineThreshold quarantines a vhost that panics more than this many times within QuarantineWindow,
+	// a quarantined vhost is answered by QuarantineHandler until the quarantine ends. Requires Recover.
+	//
+	// Optional. Default: 0 ( never quarantine )
+	QuarantineThreshold int
+
+	// QuarantineWindow is the window the panics are counted in
+	//
+	// Optional. Default: 1 * time.Minute
+	QuarantineWindow time.Duration
+
+	// QuarantineDuration is how long a vhost stays quarantined
+	//
+	// Optional. Default: 0 ( until Vhosts.Release is called or the vhost is removed )
+	QuarantineDuration time.Duration
+
+	// QuarantineHandler renders the response for a quarantined vhost
+	//
+	// Optional. Default: 503 Service Unavailable
+	QuarantineHandler fiber.Handler
+
+	// UnknownHost is the policy for requests for hostnames without a vhost
+	//
+	// Optional. Default: UnknownHostNotFound
+	UnknownHost UnknownHostPolicy
+
+	// DefaultHostname is the hostname of the vhost serving unknown hostnames with UnknownHostDefault
+	//
+	// Optional. Default: ""
+	DefaultHostname string
+
+	// NotFoundHandler renders the response for unknown hostnames instead of a plain 404, with UnknownHostNotFound,
+	// UnknownHostHandler and with UnknownHostDefault when the default vhost doesn't exist either
+	//
+	// Optional. Default: nil
+	NotFoundHandler fiber.Handler
+}
+
+// ConfigDefault is the default config
+var ConfigDefault = Config{
+	Next:             nil,
+	Registry:         nil,
+	HostExtractor:    hostnameExtractor,
+	LocalsPrefix:     "vhost.",
+	UnknownHost:      UnknownHostNotFound,
+	QuarantineWindow: 1 * time.Minute,
+	QuarantineHandler: func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusServiceUnavailable)
+	},
+}
+
+// configDefault returns the given config ( if any ) with the defaults applied
+func configDefault(config ...Config) Config {
+	if len(config) < 1 {
+		return ConfigDefault
+	}
+	cfg := config[0]
+
+	// Set default values
+	if cfg.HostExtractor == nil {
+		cfg.HostExtractor = ConfigDefault.HostExtractor
+	}
+	if cfg.LocalsPrefix == "" {
+		cfg.LocalsPrefix = ConfigDefault.LocalsPrefix
+	}
+	if cfg.QuarantineWindow <= 0 {
+		cfg.QuarantineWindow = ConfigDefault.QuarantineWindow
+	}
+	if cfg.QuarantineHandler == nil {
+		cfg.QuarantineHandler = ConfigDefault.QuarantineHandler
+	}
+	return cfg
+}
+
+// closeConnection closes the connection of the request without sending a response
+func closeConnection(c *fiber.Ctx) {
+	c.Context().HijackSetNoResponse(true)
+	c.Context().Hijack(func(net.Conn) {})
+}
+
+// hostnameExtractor returns the hostname of the request as reported by fiber
+func hostnameExtractor(c *fiber.Ctx) string {
+	return c.Hostname()
+}
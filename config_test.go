@@ -0,0 +1,85 @@
+package vhosts
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXVhost_UnknownHost(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.Add(NewVhost("default.example.com", "", "", func(c *fiber.Ctx) error {
+		return c.SendString("default")
+	}, mockErrorHandler))
+
+	tests := []struct {
+		name   string
+		config Config
+		status int
+		body   string
+	}{
+		{"not found", Config{}, 404, "Not Found"},
+		{"default vhost", Config{UnknownHost: UnknownHostDefault, DefaultHostname: "default.example.com"}, 200, "default"},
+		{"missing default vhost", Config{UnknownHost: UnknownHostDefault, DefaultHostname: "missing.example.com"}, 404, "Not Found"},
+		{"next", Config{UnknownHost: UnknownHostNext}, 200, "app"},
+		{"handler", Config{UnknownHost: UnknownHostHandler, NotFoundHandler: func(c *fiber.Ctx) error {
+			return c.Status(421).SendString("domain not configured")
+		}}, 421, "domain not configured"},
+		{"handler without handler", Config{UnknownHost: UnknownHostHandler}, 404, "Not Found"},
+		// the handler is used whenever it is set
+		{"not found handler", Config{NotFoundHandler: func(c *fiber.Ctx) error {
+			return c.Status(421).SendString("domain not configured")
+		}}, 421, "domain not configured"},
+		{"missing default vhost handler", Config{UnknownHost: UnknownHostDefault, DefaultHostname: "missing.example.com", NotFoundHandler: func(c *fiber.Ctx) error {
+			return c.Status(421).SendString("domain not configured")
+		}}, 421, "domain not configured"},
+	}
+	for _, tt := range tests {
+		app := fiber.New()
+		app.Use(XVhost(vhosts, tt.config))
+		app.Get("/", func(c *fiber.Ctx) error {
+			return c.SendString("app")
+		})
+
+		resp, err := app.Test(httptest.NewRequest("GET", "http://unknown.example.com/", nil))
+		assert.Nil(t, err, tt.name)
+		assert.Equal(t, tt.status, resp.StatusCode, tt.name)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, tt.body, string(body), tt.name)
+	}
+}
+
+func TestXVhost_UnknownHostClose(t *testing.T) {
+	app := fiber.New()
+	app.Use(XVhost(&Vhosts{}, Config{UnknownHost: UnknownHostClose}))
+
+	// the connection is closed without a response
+	_, err := app.Test(httptest.NewRequest("GET", "http://unknown.example.com/", nil), int(time.Second/time.Millisecond))
+	assert.NotNil(t, err)
+}
+
+func TestXVhost_NoRegistry(t *testing.T) {
+	vhs := Vhs
+	defer func() { Vhs = vhs }()
+	Vhs = nil
+
+	// without a registry and a package level vhosts list every hostname is unknown
+	app := fiber.New()
+	app.Use(New())
+	resp, err := app.Test(httptest.NewRequest("GET", "http://example.com/", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+
+	app = fiber.New()
+	app.Use(New(Config{UnknownHost: UnknownHostNext}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("app")
+	})
+	resp, err = app.Test(httptest.NewRequest("GET", "http://example.com/", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+}
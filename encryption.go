@@ -0,0 +1,133 @@
+package vhosts
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// encryptionPrefix starts the header line in front of the ciphertext of an encrypted vhosts file,
+// v1 is the version of the encryption format
+const encryptionPrefix = "vhosts-encrypted:v1:aes-gcm:"
+
+// encryptionMagic matches the header line of every version of the encryption format
+const encryptionMagic = "vhosts-encrypted:"
+
+var (
+	// ErrUnencrypted is returned by Load when encryption is configured and the file isn't encrypted
+	ErrUnencrypted = errors.New("vhosts file isn't encrypted")
+	// ErrNoEncryptionKey is returned by Load when the file is encrypted and no encryption is configured
+	ErrNoEncryptionKey = errors.New("vhosts file is encrypted and no key is configured")
+	// ErrDecrypt is returned by Load when the file can't be decrypted, the key is wrong or the file was modified
+	ErrDecrypt = errors.New("vhosts file can't be decrypted")
+)
+
+// KeyFunc returns the AES key ( 16, 24 or 32 bytes ) with the given id
+type KeyFunc func(keyID string) ([]byte, error)
+
+// EnvKeys returns the keys from the environment, the key with id "2026" is read base64 encoded from the
+// variable prefix+"2026"
+func EnvKeys(prefix string) KeyFunc {
+	return func(keyID string) ([]byte, error) {
+		value, ok := os.LookupEnv(prefix + keyID)
+		if !ok {
+			return nil, fmt.Errorf("encryption key %s isn't set", prefix+keyID)
+		}
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	}
+}
+
+// WithEncryption encrypts the files written by Save with AES-GCM using the key with the given id, and makes Load
+// refuse files that aren't encrypted. Load looks up the key by the id written in the header of the file, keys
+// can be rotated by returning the old keys as well.
+func WithEncryption(keyID string, keys KeyFunc) FileOption {
+	return func(o *fileOptions) {
+		o.keyID = keyID
+		o.keys = keys
+	}
+}
+
+// aead returns the AES-GCM cipher for the key with the given id
+func (o fileOptions) aead(keyID string) (cipher.AEAD, error) {
+	key, err := o.keys(keyID)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the payload if encryption is configured. The header line carries the key id and the nonce and is
+// authenticated as additional data.
+func (o fileOptions) seal(payload []byte) ([]byte, error) {
+	if o.keys == nil {
+		return payload, nil
+	}
+	if strings.ContainsAny(o.keyID, ":\n") || o.keyID == "" {
+		return nil, errors.New("invalid encryption key id")
+	}
+	aead, err := o.aead(o.keyID)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	header := encryptionPrefix + o.keyID + ":" + base64.StdEncoding.EncodeToString(nonce) + "\n"
+	out := make([]byte, 0, len(header)+len(payload)+aead.Overhead())
+	out = append(out, header...)
+	return aead.Seal(out, nonce, payload, []byte(header)), nil
+}
+
+// open decrypts the data if it is encrypted
+func (o fileOptions) open(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(encryptionMagic)) {
+		if o.keys != nil {
+			return nil, ErrUnencrypted
+		}
+		return data, nil
+	}
+	if o.keys == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	if !bytes.HasPrefix(data, []byte(encryptionPrefix)) {
+		return nil, errors.New("unsupported vhosts encryption format")
+	}
+
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		return nil, ErrDecrypt
+	}
+	header, ciphertext := data[:end+1], data[end+1:]
+	fields := strings.Split(string(data[len(encryptionPrefix):end]), ":")
+	if len(fields) != 2 {
+		return nil, ErrDecrypt
+	}
+	nonce, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	aead, err := o.aead(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	payload, err := aead.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return payload, nil
+}
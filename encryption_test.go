@@ -0,0 +1,111 @@
+package vhosts
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// staticKeys returns the given keys by id
+func staticKeys(keys map[string][]byte) KeyFunc {
+	return func(keyID string) ([]byte, error) {
+		key, ok := keys[keyID]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return key, nil
+	}
+}
+
+func TestEncryption_RoundTrip(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vhosts.json")
+	keys := staticKeys(map[string][]byte{"2026": bytes.Repeat([]byte{1}, 32)})
+	vhosts := newPersistedVhosts(t)
+	assert.Nil(t, vhosts.Save(file, WithEncryption("2026", keys)))
+
+	// the file carries the key id in clear but none of the vhosts
+	data, err := os.ReadFile(file)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "vhosts-encrypted:v1:aes-gcm:2026:"))
+	assert.False(t, bytes.Contains(data, []byte("example.com")))
+
+	loaded := &Vhosts{}
+	assert.Nil(t, loaded.Load(file, WithEncryption("2026", keys)))
+	assert.Equal(t, vhosts.Vhosts, loaded.Vhosts)
+	report, err := VerifyFile(file, WithEncryption("2026", keys))
+	assert.Nil(t, err)
+	assert.True(t, report.Valid)
+
+	// a second save uses a fresh nonce
+	assert.Nil(t, vhosts.Save(file, WithEncryption("2026", keys)))
+	again, err := os.ReadFile(file)
+	assert.Nil(t, err)
+	assert.NotEqual(t, data, again)
+}
+
+func TestEncryption_Refused(t *testing.T) {
+	dir := t.TempDir()
+	keys := staticKeys(map[string][]byte{
+		"2025": bytes.Repeat([]byte{1}, 32),
+		"2026": bytes.Repeat([]byte{2}, 16),
+	})
+	vhosts := newPersistedVhosts(t)
+
+	plain := filepath.Join(dir, "plain.bin")
+	assert.Nil(t, vhosts.Save(plain))
+	assert.ErrorIs(t, (&Vhosts{}).Load(plain, WithEncryption("2026", keys)), ErrUnencrypted)
+
+	encrypted := filepath.Join(dir, "encrypted.bin")
+	assert.Nil(t, vhosts.Save(encrypted, WithEncryption("2025", keys)))
+	assert.ErrorIs(t, (&Vhosts{}).Load(encrypted), ErrNoEncryptionKey)
+	// the old key is looked up by the id in the header
+	assert.Nil(t, (&Vhosts{}).Load(encrypted, WithEncryption("2026", keys)))
+	// a wrong key doesn't decrypt
+	wrong := staticKeys(map[string][]byte{"2025": bytes.Repeat([]byte{3}, 32)})
+	assert.ErrorIs(t, (&Vhosts{}).Load(encrypted, WithEncryption("2025", wrong)), ErrDecrypt)
+	// an unknown key id fails with the error of the key function
+	assert.ErrorIs(t, (&Vhosts{}).Load(encrypted, WithEncryption("2025", staticKeys(nil))), os.ErrNotExist)
+}
+
+func TestEncryption_Tampered(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vhosts.bin")
+	key := bytes.Repeat([]byte{1}, 32)
+	keys := staticKeys(map[string][]byte{"a": key, "b": key})
+	vhosts := newPersistedVhosts(t)
+	assert.Nil(t, vhosts.Save(file, WithEncryption("a", keys)))
+	data, err := os.ReadFile(file)
+	assert.Nil(t, err)
+
+	// the header is authenticated, pointing it at another id of the same key fails
+	assert.Nil(t, os.WriteFile(file, bytes.Replace(data, []byte(":aes-gcm:a:"), []byte(":aes-gcm:b:"), 1), 0644))
+	assert.ErrorIs(t, (&Vhosts{}).Load(file, WithEncryption("a", keys)), ErrDecrypt)
+
+	tampered := bytes.Clone(data)
+	tampered[len(tampered)-1] ^= 1
+	assert.Nil(t, os.WriteFile(file, tampered, 0644))
+	assert.ErrorIs(t, (&Vhosts{}).Load(file, WithEncryption("a", keys)), ErrDecrypt)
+
+	assert.Nil(t, os.WriteFile(file, []byte("vhosts-encrypted:v9:xchacha:a:AAAA\n"), 0644))
+	assert.EqualError(t, (&Vhosts{}).Load(file, WithEncryption("a", keys)), "unsupported vhosts encryption format")
+}
+
+func TestEncryption_EnvKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vhosts.yaml")
+	t.Setenv("VHOSTS_KEY_2026", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	vhosts := newPersistedVhosts(t)
+
+	// encrypted files can be signed as well
+	signer := NewHMACKey("build-1", []byte("secret"))
+	assert.Nil(t, vhosts.Save(file, WithEncryption("2026", EnvKeys("VHOSTS_KEY_")), WithSigner(signer)))
+	loaded := &Vhosts{}
+	assert.Nil(t, loaded.Load(file, WithEncryption("2026", EnvKeys("VHOSTS_KEY_")), WithTrustedKeys(signer)))
+	assert.Len(t, loaded.Vhosts, 3)
+
+	assert.EqualError(t, vhosts.Save(file, WithEncryption("2025", EnvKeys("VHOSTS_KEY_"))), "encryption key VHOSTS_KEY_2025 isn't set")
+	assert.Error(t, vhosts.Save(file, WithEncryption("a:b", EnvKeys("VHOSTS_KEY_"))))
+}
@@ -0,0 +1,191 @@
+package vhosts
+
+import (
+	"slices"
+	"sync"
+)
+
+// EventType is the type of a change to the vhosts list
+type EventType int
+
+const (
+	// EventAdded is emitted when a vhost is added, New is the added vhost
+	EventAdded EventType = iota + 1
+	// EventRemoved is emitted when a vhost is removed, Old is the removed vhost
+	EventRemoved
+	// EventHandlerChanged is emitted when the handler ( or the path routes ) of a vhost changed
+	EventHandlerChanged
+	// EventErrorHandlerChanged is emitted when the error handler of a vhost changed
+	EventErrorHandlerChanged
+	// EventReloaded is emitted when the handlers of all the vhosts were reloaded
+	EventReloaded
+	// EventLoaded is emitted when the vhosts list was loaded from a file
+	EventLoaded
+)
+
+// String returns the name of the event type
+func (t EventType) String() string {
+	switch t {
+	case EventAdded:
+		return "added"
+	case EventRemoved:
+		return "removed"
+	case EventHandlerChanged:
+		return "handler changed"
+	case EventErrorHandlerChanged:
+		return "error handler changed"
+	case EventReloaded:
+		return "reloaded"
+	case EventLoaded:
+		return "loaded"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a change to the vhosts list
+type Event struct {
+	Type     EventType // type is the type of the change
+	Hostname string    // hostname is the hostname of the changed vhost, empty for EventReloaded and EventLoaded
+	Old      *Vhost    // old is the vhost before the change, nil if the vhost was added
+	New      *Vhost    // new is the vhost after the change, nil if the vhost was removed
+	Version  int64     // version is the version of the vhosts list after the change
+}
+
+// subscription is a callback registered with Subscribe
+type subscription struct {
+	fn func(event Event)
+}
+
+// Subscribe registers fn to be called for every change to the vhosts list and returns a function cancelling
+// the subscription. Events are delivered in the order of the changes, after the vhosts list is unlocked,
+// so fn may read the vhosts list but must not change it. A slow fn delays the return of the changes but
+// doesn't block the vhosts list.
+func (v *Vhosts) Subscribe(fn func(event Event)) (unsubscribe func()) {
+	sub := &subscription{fn: fn}
+	v.subscriberMutex.Lock()
+	v.subscribers = append(v.subscribers, sub)
+	v.subscriberMutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			v.subscriberMutex.Lock()
+			defer v.subscriberMutex.Unlock()
+			for i, s := range v.subscribers {
+				if s == sub {
+					v.subscribers = append(v.subscribers[:i:i], v.subscribers[i+1:]...)
+					break
+				}
+			}
+		})
+	}
+}
+
+// Events returns a channel receiving every change to the vhosts list and a function cancelling the subscription.
+// Changes never wait for the channel, the events are queued for a consumer that falls behind and sent in order.
+// The cancel function drops the queued events and closes the channel.
+func (v *Vhosts) Events(buffer int) (<-chan Event, func()) {
+	events := make(chan Event, buffer)
+	done := make(chan struct{})
+	ready := make(chan struct{}, 1)
+	var mutex sync.Mutex
+	var queue []Event
+	unsubscribe := v.Subscribe(func(event Event) {
+		mutex.Lock()
+		queue = append(queue, event)
+		mutex.Unlock()
+		select {
+		case ready <- struct{}{}:
+		default:
+		}
+	})
+
+	// forward the queued events to the channel until the subscription is cancelled
+	go func() {
+		defer close(events)
+		for {
+			select {
+			case <-ready:
+			case <-done:
+				return
+			}
+			mutex.Lock()
+			pending := queue
+			queue = nil
+			mutex.Unlock()
+			for _, event := range pending {
+				select {
+				case events <- event:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			unsubscribe()
+			close(done)
+		})
+	}
+}
+
+// emit queues an event delivered when the vhosts list is unlocked with unlock, the caller must hold the write lock.
+// The vhosts of the event are copies, a subscriber changing them doesn't change the vhosts list or the routing table.
+func (v *Vhosts) emit(event Event) {
+	event.Old, event.New = cloneVhost(event.Old), cloneVhost(event.New)
+	v.pending = append(v.pending, event)
+}
+
+// cloneVhost returns a copy of the vhost that shares no slices with it
+func cloneVhost(vhost *Vhost) *Vhost {
+	if vhost == nil {
+		return nil
+	}
+	clone := *vhost
+	clone.Ports = slices.Clone(vhost.Ports)
+	clone.Aliases = slices.Clone(vhost.Aliases)
+	clone.Routes = slices.Clone(vhost.Routes)
+	return &clone
+}
+
+// unlock releases the write lock and delivers the queued events. The events of concurrent changes are
+// delivered one change after the other, in the order the changes were made. The changes are numbered while
+// holding the write lock and wait for their turn after releasing it, so slow subscribers never block the lock.
+func (v *Vhosts) unlock() {
+	events := v.pending
+	v.pending = nil
+	if len(events) == 0 {
+		v.mutex.Unlock()
+		return
+	}
+	v.emitted++
+	turn := v.emitted
+	v.mutex.Unlock()
+
+	v.emitMutex.Lock()
+	defer v.emitMutex.Unlock()
+	if v.emitTurn == nil {
+		v.emitTurn = sync.NewCond(&v.emitMutex)
+	}
+	for v.delivered != turn-1 {
+		v.emitTurn.Wait()
+	}
+	// hand over to the next change even if a subscriber panics
+	defer func() {
+		v.delivered = turn
+		v.emitTurn.Broadcast()
+	}()
+
+	v.subscriberMutex.Lock()
+	subscribers := v.subscribers
+	v.subscriberMutex.Unlock()
+	for _, event := range events {
+		for _, sub := range subscribers {
+			sub.fn(event)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package vhosts
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVhosts_Subscribe(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	assert.Nil(t, vhosts.AddHandler("blog", mockMiddleware))
+
+	var events []Event
+	unsubscribe := vhosts.Subscribe(func(event Event) {
+		// the vhosts list is readable from the callback
+		_, ok := vhosts.Get("a.example.com")
+		assert.Equal(t, event.Type != EventRemoved, ok)
+		events = append(events, event)
+	})
+
+	assert.Nil(t, vhosts.Add(NewVhost("a.example.com", "", "a", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.AddRoute("a.example.com", "/blog", "blog", false))
+	assert.Nil(t, vhosts.Update(func(tx *Tx) error {
+		if err := tx.SetHandlerByTag("a.example.com", "blog"); err != nil {
+			return err
+		}
+		return tx.SetErrorHandler("a.example.com", nil)
+	}))
+	assert.Nil(t, vhosts.ReloadHandlers())
+	assert.Nil(t, vhosts.Remove("a.example.com"))
+	// failed changes aren't emitted
+	assert.Error(t, vhosts.Remove("a.example.com"))
+
+	unsubscribe()
+	assert.Nil(t, vhosts.Add(NewVhost("b.example.com", "", "b", mockMiddleware, mockErrorHandler)))
+
+	types := make([]EventType, len(events))
+	for i, event := range events {
+		types[i] = event.Type
+	}
+	assert.Equal(t, []EventType{EventAdded, EventHandlerChanged, EventHandlerChanged, EventErrorHandlerChanged, EventReloaded, EventRemoved}, types)
+
+	assert.Equal(t, "a.example.com", events[0].Hostname)
+	assert.Nil(t, events[0].Old)
+	assert.Equal(t, "a", events[0].New.WebsiteID)
+	assert.Equal(t, int64(1), events[0].Version)
+
+	assert.Empty(t, events[1].Old.Routes)
+	assert.Len(t, events[1].New.Routes, 1)
+	assert.Equal(t, int64(2), events[1].Version)
+
+	// the changes of a batch share its version
+	assert.Equal(t, "", events[2].Old.Path)
+	assert.Equal(t, "blog", events[2].New.Path)
+	assert.Equal(t, int64(3), events[2].Version)
+	assert.Equal(t, int64(3), events[3].Version)
+	assert.NotNil(t, events[3].Old.ErrorHandler)
+	assert.Nil(t, events[3].New.ErrorHandler)
+
+	assert.Equal(t, "", events[4].Hostname)
+	assert.Equal(t, int64(4), events[4].Version)
+	assert.Equal(t, "a", events[5].Old.WebsiteID)
+	assert.Nil(t, events[5].New)
+	assert.Equal(t, int64(5), events[5].Version)
+}
+
+func TestVhosts_Subscribe_Loaded(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vhosts.bin")
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	assert.Nil(t, vhosts.Add(NewVhost("a.example.com", "", "a", nil, nil)))
+	assert.Nil(t, vhosts.Save(file))
+
+	loaded := &Vhosts{}
+	events, cancel := loaded.Events(1)
+	defer cancel()
+	assert.Nil(t, loaded.Load(file))
+	event := <-events
+	assert.Equal(t, EventLoaded, event.Type)
+	// the version of the file is 1, loading is a change of its own
+	assert.Equal(t, int64(2), event.Version)
+}
+
+func TestVhosts_Events_Cancel(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	events, cancel := vhosts.Events(0)
+
+	// changes don't wait for a consumer that isn't reading, the events are queued in order
+	assert.Nil(t, vhosts.Add(NewVhost("a.example.com", "", "a", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.Add(NewVhost("b.example.com", "", "b", mockMiddleware, mockErrorHandler)))
+	assert.Equal(t, 2, vhosts.NumberOfVhosts())
+	assert.Equal(t, "a.example.com", (<-events).Hostname)
+	assert.Equal(t, "b.example.com", (<-events).Hostname)
+
+	// cancelling closes the channel, a consumer ranging over it stops
+	assert.Nil(t, vhosts.Add(NewVhost("c.example.com", "", "c", mockMiddleware, mockErrorHandler)))
+	cancel()
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range events {
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the channel wasn't closed")
+	}
+}
+
+func TestVhosts_Subscribe_Slow(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	release := make(chan struct{})
+	defer vhosts.Subscribe(func(event Event) {
+		<-release
+	})()
+
+	// a change waiting for a slow subscriber doesn't block the vhosts list
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.Nil(t, vhosts.Add(NewVhost("a.example.com", "", "a", mockMiddleware, mockErrorHandler)))
+	}()
+	assert.Eventually(t, func() bool { return vhosts.GetVersion() == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, 1, vhosts.NumberOfVhosts())
+	go func() {
+		assert.Nil(t, vhosts.Add(NewVhost("b.example.com", "", "b", mockMiddleware, mockErrorHandler)))
+	}()
+	assert.Eventually(t, func() bool { return vhosts.NumberOfVhosts() == 2 }, time.Second, time.Millisecond)
+	close(release)
+	<-done
+}
+
+func TestVhosts_Subscribe_Order(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+
+	var mutex sync.Mutex
+	var versions []int64
+	defer vhosts.Subscribe(func(event Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		versions = append(versions, event.Version)
+	})()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			hostname := string(rune('a'+i%26)) + string(rune('a'+i/26)) + ".example.com"
+			assert.Nil(t, vhosts.Add(NewVhost(hostname, "", "", mockMiddleware, mockErrorHandler)))
+		}(i)
+	}
+	wg.Wait()
+
+	// concurrent changes are delivered in the order of their versions
+	assert.Len(t, versions, 50)
+	for i, version := range versions {
+		assert.Equal(t, int64(i+1), version)
+	}
+}
+
+func TestVhosts_Subscribe_Copies(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	assert.Nil(t, vhosts.AddHandler("blog", mockMiddleware))
+
+	// a subscriber scribbling over the events doesn't change the vhosts served
+	vhosts.Subscribe(func(event Event) {
+		if event.New != nil {
+			event.New.WebsiteID = "changed"
+			for i := range event.New.Routes {
+				event.New.Routes[i].HandlerTag = "changed"
+			}
+		}
+	})
+	assert.Nil(t, vhosts.Add(NewVhost("a.example.com", "", "a", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.AddRoute("a.example.com", "/blog", "blog", false))
+	assert.Nil(t, vhosts.Update(func(tx *Tx) error {
+		return tx.Add(NewVhost("b.example.com", "", "b", mockMiddleware, mockErrorHandler))
+	}))
+
+	vhost, ok := vhosts.Get("a.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "a", vhost.WebsiteID)
+	assert.Equal(t, "blog", vhost.Routes[0].HandlerTag)
+	published, _, ok := vhosts.routing().match("a.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "a", published.WebsiteID)
+	vhost, ok = vhosts.Get("b.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "b", vhost.WebsiteID)
+}
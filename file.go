@@ -0,0 +1,123 @@
+package vhosts
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// WithBackups keeps the given number of previous versions of the file when saving ( file.1 is the newest ),
+// Load falls back to them, newest first, when the file can't be loaded or fails checksum verification
+func WithBackups(backups int) FileOption {
+	return func(o *fileOptions) {
+		o.backups = max(backups, 0)
+	}
+}
+
+// backupFile returns the name of the i-th backup of the given file
+func backupFile(file string, i int) string {
+	return file + "." + strconv.Itoa(i)
+}
+
+// writeFileAtomic replaces the given file with the output of write. The output goes to a temporary file in the
+// same directory which is synced and renamed over the file, so a crash leaves either the old or the new file.
+// The previous versions of the file are rotated into the given number of backups first.
+func writeFileAtomic(file string, backups int, write func(w io.Writer) error) error {
+	dir := filepath.Dir(file)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(file)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// remove the temporary file unless it was renamed
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if backups > 0 {
+		if err := rotateBackups(file, backups); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// rotateBackups shifts the backups of the given file by one and copies the file to the first backup
+func rotateBackups(file string, backups int) error {
+	if !doesFileExist(file) {
+		return nil
+	}
+	for i := backups - 1; i > 0; i-- {
+		if !doesFileExist(backupFile(file, i)) {
+			continue
+		}
+		if err := os.Rename(backupFile(file, i), backupFile(file, i+1)); err != nil {
+			return err
+		}
+	}
+
+	// link the file instead of renaming it so there is never a moment without the file
+	first := backupFile(file, 1)
+	if err := os.Remove(first); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Link(file, first); err == nil {
+		return nil
+	}
+	return copyFile(file, first)
+}
+
+// copyFile copies the file src to dst, used where hard links aren't supported
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// syncDir syncs the directory so a rename in it survives a crash
+func syncDir(dir string) error {
+	// directories can't be synced on windows
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,123 @@
+package vhosts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingCodec fails halfway through encoding
+type failingCodec struct{}
+
+func (failingCodec) Encode(w io.Writer, v *Vhosts) error {
+	w.Write([]byte("partial"))
+	return errors.New("disk full")
+}
+
+func (failingCodec) Decode(r io.Reader, v *Vhosts) error {
+	return errors.New("not implemented")
+}
+
+// listDir returns the names of the files in the directory
+func listDir(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestSave_Atomic(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "vhosts.bin")
+	vhosts := newPersistedVhosts(t)
+	assert.Nil(t, vhosts.Save(file))
+
+	// a failed save leaves the previous file alone and no temporary file behind
+	assert.EqualError(t, vhosts.Save(file, WithCodec(failingCodec{})), "disk full")
+	assert.Equal(t, []string{"vhosts.bin"}, listDir(t, dir))
+	loaded := &Vhosts{}
+	assert.Nil(t, loaded.Load(file))
+	assert.Len(t, loaded.Vhosts, 3)
+
+	info, err := os.Stat(file)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+}
+
+func TestSave_Shrink(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vhosts.bin")
+	vhosts := newPersistedVhosts(t)
+	assert.Nil(t, vhosts.Save(file))
+	before, err := os.Stat(file)
+	assert.Nil(t, err)
+
+	// a smaller vhosts list leaves no trailing bytes of the previous file
+	assert.Nil(t, vhosts.Remove("example.com"))
+	assert.Nil(t, EncodeAsGob(file, vhosts))
+	after, err := os.Stat(file)
+	assert.Nil(t, err)
+	assert.Less(t, after.Size(), before.Size())
+}
+
+func TestSave_Backups(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "vhosts.json")
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	for i := 1; i <= 4; i++ {
+		assert.Nil(t, vhosts.Add(NewVhost(fmt.Sprintf("host%d.example.com", i), "", "", nil, nil)))
+		assert.Nil(t, vhosts.Save(file, WithBackups(2)))
+	}
+	assert.Equal(t, []string{"vhosts.json", "vhosts.json.1", "vhosts.json.2"}, listDir(t, dir))
+
+	// the newest backup is the previous save
+	for i, name := range []string{"vhosts.json", "vhosts.json.1", "vhosts.json.2"} {
+		loaded := &Vhosts{}
+		assert.Nil(t, loaded.Load(filepath.Join(dir, name), WithCodec(JSONCodec)))
+		assert.Len(t, loaded.Vhosts, 4-i)
+	}
+}
+
+func TestLoad_Backups(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "vhosts.bin")
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	for i := 1; i <= 3; i++ {
+		assert.Nil(t, vhosts.Add(NewVhost(fmt.Sprintf("host%d.example.com", i), "", "", nil, nil)))
+		assert.Nil(t, vhosts.Save(file, WithBackups(2)))
+	}
+
+	// break the checksum of the primary file
+	data, err := os.ReadFile(file)
+	assert.Nil(t, err)
+	data[len(data)-5] ^= 0xff
+	assert.Nil(t, os.WriteFile(file, data, 0644))
+
+	assert.EqualError(t, (&Vhosts{}).Load(file), "vhosts list checksum doesn't match")
+	loaded := &Vhosts{}
+	assert.Nil(t, loaded.Load(file, WithBackups(2)))
+	assert.Len(t, loaded.Vhosts, 2)
+	_, ok := loaded.Get("host2.example.com")
+	assert.True(t, ok)
+
+	// a broken backup is skipped too, and a missing primary falls back as well
+	assert.Nil(t, os.WriteFile(backupFile(file, 1), []byte("garbage"), 0644))
+	assert.Nil(t, os.Remove(file))
+	loaded = &Vhosts{}
+	assert.Nil(t, loaded.Load(file, WithBackups(2)))
+	assert.Len(t, loaded.Vhosts, 1)
+
+	// the error of the primary file is returned when every backup fails
+	assert.Nil(t, os.Remove(backupFile(file, 2)))
+	assert.EqualError(t, loaded.Load(file, WithBackups(2)), "file doesn't exist")
+	assert.Len(t, loaded.Vhosts, 1)
+}
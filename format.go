@@ -0,0 +1,189 @@
+package vhosts
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// FormatVersion is the version of the vhosts file format written by Save. The JSON and YAML formats store it in
+// the format field, the gob format in a header line in front of the vhosts list. Files without it are version 1.
+//
+//   - version 1: the vhosts list as gob, JSON or YAML, the checksum is the legacy checksum ( see Hash ) or a
+//     version 2 checksum
+//   - version 2: the format version is stored, the checksum is always a version 2 checksum
+const FormatVersion = 2
+
+// formatPrefix starts the header line in front of the gob encoded vhosts list
+const formatPrefix = "vhosts-format:"
+
+// ErrUnsupportedFormat is returned by Load for files written by a newer version of the package, loading them
+// would silently drop the fields this version doesn't know about
+var ErrUnsupportedFormat = errors.New("unsupported vhosts file format")
+
+// fileLayout is the layout of the vhosts list in the files of one format version. The layouts of older versions
+// never change, so files of every version still decode after the fields of Vhost or Vhosts changed.
+type fileLayout interface {
+	// vhosts returns the vhosts list stored in the layout, as written to the file
+	vhosts() (*Vhosts, error)
+}
+
+// migration upgrades the layout of a format version to the layout of the next version
+type migration struct {
+	from    int                                         // from is the format version the migration upgrades
+	layout  func() fileLayout                           // layout returns an empty layout of version from to decode into
+	migrate func(layout fileLayout) (fileLayout, error) // migrate converts the layout to the layout of version from+1
+}
+
+// migrations upgrades the layouts of older files step by step, there is one migration per format version
+var migrations = []migration{
+	{from: 1, layout: func() fileLayout { return &vhostsFileV1{} }, migrate: migrateV1},
+}
+
+// layoutOf returns an empty layout of the given format version
+func layoutOf(version int) fileLayout {
+	if version == FormatVersion {
+		return &vhostsFile{}
+	}
+	return migrations[version-1].layout()
+}
+
+// migrate upgrades the layout decoded from a file of the given format version to the current version
+func migrate(layout fileLayout, version int) (fileLayout, error) {
+	for _, m := range migrations {
+		if m.from < version {
+			continue
+		}
+		var err error
+		if layout, err = m.migrate(layout); err != nil {
+			return nil, fmt.Errorf("migrating vhosts file format %d: %w", m.from, err)
+		}
+		log.Debugf("migrated vhosts file format %d to %d", m.from, m.from+1)
+	}
+	return layout, nil
+}
+
+// checkFormat returns an error for format versions this version of the package can't read
+func checkFormat(version int) error {
+	if version < 1 {
+		return errors.New("invalid vhosts file format")
+	}
+	if version > FormatVersion {
+		return fmt.Errorf("%w %d, the newest supported format is %d", ErrUnsupportedFormat, version, FormatVersion)
+	}
+	return nil
+}
+
+// formatHeader returns the header line of the current format version
+func formatHeader() []byte {
+	return []byte(formatPrefix + strconv.Itoa(FormatVersion) + "\n")
+}
+
+// readFormat strips the header line from the gob payload and returns the format version
+func readFormat(payload []byte) (int, []byte, error) {
+	if !bytes.HasPrefix(payload, []byte(formatPrefix)) {
+		return 1, payload, nil
+	}
+	end := bytes.IndexByte(payload, '\n')
+	if end < 0 {
+		return 0, nil, errors.New("invalid vhosts file format header")
+	}
+	version, err := strconv.Atoi(string(payload[len(formatPrefix):end]))
+	if err != nil || version < 2 {
+		return 0, nil, errors.New("invalid vhosts file format header")
+	}
+	return version, payload[end+1:], nil
+}
+
+// vhostsFileV1 is the layout of format version 1, the gob format is the Vhosts struct of the time and the text
+// formats hex encode legacy checksums ( decoded by the codecs )
+type vhostsFileV1 struct {
+	Vhosts       []vhostFileV1     `json:"vhosts" yaml:"vhosts"`
+	LastModified int64             `json:"lastModified" yaml:"lastModified"`
+	Version      int64             `json:"version" yaml:"version"`
+	Checksum     string            `json:"checksum" yaml:"checksum"`
+	Checksums    map[string]string `json:"checksums,omitempty" yaml:"checksums,omitempty"`
+}
+
+// vhostFileV1 is the layout of a vhost in format version 1
+type vhostFileV1 struct {
+	Hostname       string          `json:"hostname" yaml:"hostname"`
+	Path           string          `json:"path,omitempty" yaml:"path,omitempty"`
+	WebsiteID      string          `json:"websiteID,omitempty" yaml:"websiteID,omitempty"`
+	LastModified   int64           `json:"lastModified,omitempty" yaml:"lastModified,omitempty"`
+	Ports          []int           `json:"ports,omitempty" yaml:"ports,omitempty"`
+	Aliases        []string        `json:"aliases,omitempty" yaml:"aliases,omitempty"`
+	Canonical      CanonicalPolicy `json:"canonical,omitempty" yaml:"canonical,omitempty"`
+	RedirectStatus int             `json:"redirectStatus,omitempty" yaml:"redirectStatus,omitempty"`
+	Routes         []routeFileV1   `json:"routes,omitempty" yaml:"routes,omitempty"`
+}
+
+// routeFileV1 is the layout of a path route in format version 1
+type routeFileV1 struct {
+	Prefix      string `json:"prefix" yaml:"prefix"`
+	HandlerTag  string `json:"handlerTag" yaml:"handlerTag"`
+	StripPrefix bool   `json:"stripPrefix,omitempty" yaml:"stripPrefix,omitempty"`
+}
+
+// vhosts returns the vhosts list stored in the layout, the checksums are verified against it
+func (f *vhostsFileV1) vhosts() (*Vhosts, error) {
+	v := &Vhosts{
+		LastModified: f.LastModified,
+		Version:      f.Version,
+		Checksum:     f.Checksum,
+		Checksums:    f.Checksums,
+	}
+	for _, entry := range f.Vhosts {
+		vhost := Vhost{
+			Hostname:       entry.Hostname,
+			Path:           entry.Path,
+			WebsiteID:      entry.WebsiteID,
+			LastModified:   entry.LastModified,
+			Ports:          entry.Ports,
+			Aliases:        entry.Aliases,
+			Canonical:      entry.Canonical,
+			RedirectStatus: entry.RedirectStatus,
+		}
+		for _, route := range entry.Routes {
+			vhost.Routes = append(vhost.Routes, PathRoute(route))
+		}
+		v.Vhosts = append(v.Vhosts, vhost)
+	}
+	return v, nil
+}
+
+// migrateV1 converts the layout of version 1 to version 2, the fields are the same. The checksums are recomputed
+// by Load once the checksums of the version 1 file are verified.
+func migrateV1(layout fileLayout) (fileLayout, error) {
+	v1, ok := layout.(*vhostsFileV1)
+	if !ok {
+		return nil, errors.New("unexpected layout")
+	}
+	v2 := &vhostsFile{
+		Format:       2,
+		LastModified: v1.LastModified,
+		Version:      v1.Version,
+		Checksum:     v1.Checksum,
+		Checksums:    v1.Checksums,
+	}
+	for _, entry := range v1.Vhosts {
+		vhost := vhostFile{
+			Hostname:       entry.Hostname,
+			Path:           entry.Path,
+			WebsiteID:      entry.WebsiteID,
+			LastModified:   entry.LastModified,
+			Ports:          entry.Ports,
+			Aliases:        entry.Aliases,
+			Canonical:      entry.Canonical,
+			RedirectStatus: entry.RedirectStatus,
+		}
+		for _, route := range entry.Routes {
+			vhost.Routes = append(vhost.Routes, routeFile(route))
+		}
+		v2.Vhosts = append(v2.Vhosts, vhost)
+	}
+	return v2, nil
+}
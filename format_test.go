@@ -0,0 +1,195 @@
+package vhosts
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// update rewrites the golden files of the current format version, the files of older versions were written by
+// older versions of the package and must never change
+var update = flag.Bool("update", false, "rewrite the golden files of the current format version")
+
+// goldenVhosts returns the vhosts list stored in the golden files
+func goldenVhosts(t *testing.T) *Vhosts {
+	vhosts := newPersistedVhosts(t)
+	for i := range vhosts.Vhosts {
+		vhosts.Vhosts[i].LastModified = 1700000000
+	}
+	vhosts.LastModified, vhosts.Version = 1700000000, 4
+	return vhosts
+}
+
+// goldenOptions are the keys of the sealed golden files
+var goldenOptions = []FileOption{
+	WithEncryption("golden", func(string) ([]byte, error) { return []byte("0123456789abcdef0123456789abcdef"), nil }),
+	WithTrustedKeys(NewHMACKey("golden", []byte("golden secret"))),
+}
+
+func TestFormat_Golden(t *testing.T) {
+	if *update {
+		vhosts := goldenVhosts(t)
+		for _, ext := range []string{"bin", "json", "yaml"} {
+			assert.Nil(t, vhosts.Save(filepath.Join("testdata", "v2."+ext)))
+		}
+	}
+	expected := goldenVhosts(t)
+
+	files := []struct {
+		name   string
+		scheme int
+		opts   []FileOption
+	}{
+		{name: "v1-pre-codecs.bin", scheme: 1},
+		{name: "v1-legacy-checksum.bin", scheme: 1},
+		{name: "v1-legacy-checksum.json", scheme: 1},
+		{name: "v1-legacy-checksum.yaml", scheme: 1},
+		{name: "v1.bin", scheme: 2},
+		{name: "v1.json", scheme: 2},
+		{name: "v1.yaml", scheme: 2},
+		{name: "v1-sealed.yaml", scheme: 2, opts: goldenOptions},
+		{name: "v2.bin", scheme: 2},
+		{name: "v2.json", scheme: 2},
+		{name: "v2.yaml", scheme: 2},
+	}
+	for _, file := range files {
+		t.Run(file.name, func(t *testing.T) {
+			path := filepath.Join("testdata", file.name)
+			report, err := VerifyFile(path, file.opts...)
+			assert.Nil(t, err)
+			assert.Equal(t, file.scheme, report.Scheme)
+			assert.True(t, report.Valid)
+
+			loaded := &Vhosts{}
+			assert.Nil(t, loaded.Load(path, file.opts...))
+			assert.Equal(t, expected.Vhosts, loaded.Vhosts)
+			assert.Equal(t, expected.LastModified, loaded.LastModified)
+			// loading is a change of its own
+			assert.Equal(t, expected.Version+1, loaded.Version)
+			vhost, ok := loaded.Get("www.example.com")
+			assert.True(t, ok)
+			assert.Equal(t, "example.com", vhost.Hostname)
+
+			// saving the migrated list writes the current format
+			loaded.Version = expected.Version
+			for _, ext := range []string{"json", "yaml"} {
+				golden, err := os.ReadFile(filepath.Join("testdata", "v2."+ext))
+				assert.Nil(t, err)
+				saved := filepath.Join(t.TempDir(), "vhosts."+ext)
+				assert.Nil(t, loaded.Save(saved))
+				data, err := os.ReadFile(saved)
+				assert.Nil(t, err)
+				assert.Equal(t, string(golden), string(data))
+			}
+		})
+	}
+}
+
+func TestFormat_GoldenBaseline(t *testing.T) {
+	// the baseline format only stored the hostname, path and websiteID of the vhosts
+	loaded := &Vhosts{}
+	assert.Nil(t, loaded.Load(filepath.Join("testdata", "v1-baseline.bin")))
+	assert.Equal(t, []Vhost{
+		{Hostname: "example.com", Path: "shop", WebsiteID: "1", LastModified: 1700000000},
+		{Hostname: "Example.ORG", WebsiteID: "2", LastModified: 1700000000},
+		{Hostname: "blog.example.net", Path: "blog", WebsiteID: "3", LastModified: 1700000000},
+	}, loaded.Vhosts)
+	assert.True(t, strings.HasPrefix(loaded.Checksum, checksumPrefix))
+	vhost, ok := loaded.Get("example.org")
+	assert.True(t, ok)
+	assert.Equal(t, "2", vhost.WebsiteID)
+}
+
+func TestFormat_Header(t *testing.T) {
+	dir := t.TempDir()
+	vhosts := newPersistedVhosts(t)
+
+	// the text formats stay plain JSON and YAML, the version is a field of the document
+	for _, name := range []string{"vhosts.json", "vhosts.yaml"} {
+		file := filepath.Join(dir, name)
+		assert.Nil(t, vhosts.Save(file))
+		data, err := os.ReadFile(file)
+		assert.Nil(t, err)
+		var document map[string]any
+		if name == "vhosts.json" {
+			assert.Nil(t, json.Unmarshal(data, &document))
+		} else {
+			assert.Nil(t, yaml.Unmarshal(data, &document))
+		}
+		assert.EqualValues(t, 2, document["format"], name)
+
+		// files of newer format versions are refused instead of dropping the fields this version doesn't know
+		newer := strings.Replace(strings.Replace(string(data), `"format": 2`, `"format": 3`, 1), "format: 2", "format: 3", 1)
+		assert.Nil(t, os.WriteFile(file, []byte(newer), 0644))
+		assert.ErrorIs(t, (&Vhosts{}).Load(file), ErrUnsupportedFormat, name)
+	}
+
+	// gob has no room for the version, it is written in a header line
+	file := filepath.Join(dir, "vhosts.bin")
+	assert.Nil(t, vhosts.Save(file))
+	data, err := os.ReadFile(file)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "vhosts-format:2\n"))
+
+	newer := append([]byte("vhosts-format:3\n"), data[len("vhosts-format:2\n"):]...)
+	assert.Nil(t, os.WriteFile(file, newer, 0644))
+	err = (&Vhosts{}).Load(file)
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+	assert.EqualError(t, err, "unsupported vhosts file format 3, the newest supported format is 2")
+
+	for _, header := range []string{"vhosts-format:1\n", "vhosts-format:two\n", "vhosts-format:2"} {
+		assert.Nil(t, os.WriteFile(file, []byte(header), 0644))
+		assert.EqualError(t, (&Vhosts{}).Load(file), "invalid vhosts file format header", header)
+	}
+}
+
+func TestFormat_LegacyChecksumRefused(t *testing.T) {
+	// a format 2 file can't fall back to the legacy checksum, it doesn't cover the path
+	file := filepath.Join(t.TempDir(), "vhosts.bin")
+	vhosts := newPersistedVhosts(t)
+	assert.Nil(t, vhosts.Save(file))
+	vhosts.Vhosts[0].Path = "admin"
+	hash, err := Hash(vhosts.Vhosts)
+	assert.Nil(t, err)
+	vhosts.Checksum, vhosts.Checksums = hash, nil
+	assert.Nil(t, EncodeAsGob(file, vhosts))
+	assert.ErrorIs(t, (&Vhosts{}).Load(file), ErrChecksumMismatch)
+}
+
+func TestFormat_Layouts(t *testing.T) {
+	// the codecs decode older layouts without going through the current Vhost type
+	for _, name := range []string{"v1-pre-codecs.bin", "v1-legacy-checksum.json", "v1.yaml"} {
+		file := filepath.Join("testdata", name)
+		layout, format, err := decode(file, newFileOptions(file, nil))
+		assert.Nil(t, err, name)
+		assert.Equal(t, 1, format, name)
+		assert.IsType(t, &vhostsFileV1{}, layout, name)
+
+		migrated, err := migrate(layout, format)
+		assert.Nil(t, err)
+		assert.IsType(t, &vhostsFile{}, migrated, name)
+	}
+
+	// the public codec methods migrate as well
+	data, err := os.ReadFile(filepath.Join("testdata", "v1.json"))
+	assert.Nil(t, err)
+	decoded := &Vhosts{}
+	assert.Nil(t, JSONCodec.Decode(bytes.NewReader(data), decoded))
+	assert.Len(t, decoded.Vhosts, 3)
+}
+
+func TestFormat_Migrations(t *testing.T) {
+	// every older format version has exactly one migration, in order
+	assert.Len(t, migrations, FormatVersion-1)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.from)
+		assert.NotNil(t, m.layout())
+	}
+}
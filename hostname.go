@@ -0,0 +1,167 @@
+package vhosts
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// idnaProfile converts unicode labels to punycode, it doesn't enforce the strict
+// hostname rules so wildcards and underscores in labels are left alone
+var idnaProfile = idna.New(idna.MapForLookup(), idna.Transitional(false), idna.StrictDomainName(false))
+
+// NormalizeHostname returns the canonical form of a hostname as used for matching vhosts.
+// It strips the port ( if any ) and the trailing dot, lowercases the hostname and converts
+// unicode labels to punycode so that "Bücher.DE.:8080" and "xn--bcher-kva.de" are the same.
+func NormalizeHostname(hostname string) string {
+	host, _ := splitHostPort(strings.TrimSpace(hostname))
+	return normalizeLabels(host)
+}
+
+// normalizeHostname returns the hostname in the form used as key in the hostname index.
+// Raw regexes are kept as is, host templates are normalized label by label and their
+// parameter names keep their case.
+func normalizeHostname(hostname string) string {
+	if strings.HasPrefix(hostname, "~") {
+		return hostname
+	}
+	if strings.Contains(hostname, "{") {
+		return normalizeLabels(strings.TrimSpace(hostname))
+	}
+	return NormalizeHostname(hostname)
+}
+
+// normalizeLabels strips the trailing dot and lowercases ( or punycode encodes ) every label of the hostname
+func normalizeLabels(hostname string) string {
+	hostname = strings.TrimSuffix(hostname, ".")
+	labels := strings.Split(hostname, ".")
+	for i, label := range labels {
+		labels[i] = normalizeLabel(label)
+	}
+	return strings.Join(labels, ".")
+}
+
+// normalizeLabel lowercases an ascii label and converts a unicode label to punycode, template parameters are kept as is
+func normalizeLabel(label string) string {
+	if strings.Contains(label, "{") {
+		return lowerOutsideParams(label)
+	}
+	if !isASCII(label) {
+		if ascii, err := idnaProfile.ToASCII(label); err == nil {
+			return ascii
+		}
+	}
+	return strings.ToLower(label)
+}
+
+// lowerOutsideParams lowercases everything but the {name} parameters of a template label
+func lowerOutsideParams(label string) string {
+	var b strings.Builder
+	for label != "" {
+		open := strings.IndexByte(label, '{')
+		if open < 0 {
+			b.WriteString(strings.ToLower(label))
+			break
+		}
+		b.WriteString(strings.ToLower(label[:open]))
+		end := strings.IndexByte(label[open:], '}')
+		if end < 0 {
+			b.WriteString(label[open:])
+			break
+		}
+		b.WriteString(label[open : open+end+1])
+		label = label[open+end+1:]
+	}
+	return b.String()
+}
+
+// splitHostPort splits the hostname in the host and the port ( empty if there is none ), ipv6 literals lose their brackets
+func splitHostPort(hostname string) (string, string) {
+	if strings.HasPrefix(hostname, "[") {
+		end := strings.IndexByte(hostname, ']')
+		if end < 0 {
+			return hostname, ""
+		}
+		return hostname[1:end], strings.TrimPrefix(hostname[end+1:], ":")
+	}
+	// more than one colon without brackets is a bare ipv6 address
+	if colon := strings.IndexByte(hostname, ':'); colon >= 0 && strings.LastIndexByte(hostname, ':') == colon {
+		return hostname[:colon], hostname[colon+1:]
+	}
+	return hostname, ""
+}
+
+// portSuffix returns the suffix appended to index keys for the given port ( empty if there is no port )
+func portSuffix(port string) string {
+	if port == "" {
+		return ""
+	}
+	return ":" + port
+}
+
+// indexKey returns the key of the given hostname ( with optional port ) in the hostname index
+func indexKey(hostname string) string {
+	if isHostPattern(hostname) {
+		return normalizeHostname(hostname)
+	}
+	host, port := splitHostPort(strings.TrimSpace(hostname))
+	return normalizeHostname(host) + portSuffix(port)
+}
+
+// hostEntry is a normalized hostname ( or alias ) of a vhost with the port suffixes it is indexed under
+type hostEntry struct {
+	host     string
+	suffixes []string
+}
+
+// vhostEntries returns the normalized hostname and aliases of the vhost with the port suffixes they are indexed under.
+// A port in the hostname ( example.com:8443 ) is treated like an entry in Vhost.Ports and binds the aliases as well,
+// a port in an alias only binds the alias. A hostname without any port is indexed once without a port suffix and
+// matches any port.
+func vhostEntries(vhost Vhost) []hostEntry {
+	var ports []string
+	if hostname := strings.TrimSpace(vhost.Hostname); !isHostPattern(hostname) {
+		if _, port := splitHostPort(hostname); port != "" {
+			ports = append(ports, portSuffix(port))
+		}
+	}
+	for _, port := range vhost.Ports {
+		ports = append(ports, portSuffix(strconv.Itoa(port)))
+	}
+
+	entries := make([]hostEntry, 0, 1+len(vhost.Aliases))
+	for i, name := range append([]string{vhost.Hostname}, vhost.Aliases...) {
+		hostname := strings.TrimSpace(name)
+		var suffixes []string
+		if !isHostPattern(hostname) {
+			var port string
+			hostname, port = splitHostPort(hostname)
+			if port != "" && i > 0 {
+				suffixes = append(suffixes, portSuffix(port))
+			}
+		}
+		for _, port := range ports {
+			if !slices.Contains(suffixes, port) {
+				suffixes = append(suffixes, port)
+			}
+		}
+		if len(suffixes) == 0 {
+			suffixes = []string{""}
+		}
+		entries = append(entries, hostEntry{host: normalizeHostname(hostname), suffixes: suffixes})
+	}
+	return entries
+}
+
+// isASCII reports whether s only contains ascii characters
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
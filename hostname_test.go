@@ -0,0 +1,76 @@
+package vhosts
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeHostname(t *testing.T) {
+	tests := map[string]string{
+		"example.com":        "example.com",
+		"Example.COM":        "example.com",
+		"example.com.":       "example.com",
+		"example.com:8080":   "example.com",
+		"Example.com.:443":   "example.com",
+		"bücher.de":          "xn--bcher-kva.de",
+		"BÜCHER.de":          "xn--bcher-kva.de",
+		"XN--BCHER-KVA.de":   "xn--bcher-kva.de",
+		"*.bücher.de":        "*.xn--bcher-kva.de",
+		"127.0.0.1:3000":     "127.0.0.1",
+		"[::1]:3000":         "::1",
+		"::1":                "::1",
+		" example.com ":      "example.com",
+		"_dmarc.example.com": "_dmarc.example.com",
+		"例え.テスト":             "xn--r8jz45g.xn--zckzah",
+	}
+	for hostname, want := range tests {
+		assert.Equal(t, want, NormalizeHostname(hostname), hostname)
+	}
+
+	// templates keep the case of their parameter names
+	assert.Equal(t, "{Tenant}.xn--bcher-kva.de", normalizeHostname("{Tenant}.Bücher.DE."))
+	// raw regexes are kept as is
+	assert.Equal(t, `~^(?P<T>\D+)\.Example\.com$`, normalizeHostname(`~^(?P<T>\D+)\.Example\.com$`))
+}
+
+func TestXVhost_NormalizedHostname(t *testing.T) {
+	vhosts := &Vhosts{}
+	assert.Nil(t, vhosts.Add(NewVhost("Bücher.de.", "", "", mockMiddleware, mockErrorHandler)))
+
+	// unicode and punycode forms are the same vhost
+	assert.NotNil(t, vhosts.Add(NewVhost("xn--bcher-kva.de", "", "", mockMiddleware, mockErrorHandler)))
+
+	app := fiber.New()
+	app.Use(XVhost(vhosts))
+
+	for _, host := range []string{"xn--bcher-kva.de", "XN--BCHER-KVA.DE.", "xn--bcher-kva.de:8080"} {
+		resp, err := app.Test(httptest.NewRequest("GET", "http://"+host+"/", nil))
+		assert.Nil(t, err, host)
+		assert.Equal(t, 200, resp.StatusCode, host)
+	}
+}
+
+func TestVhostEntries_HostnamePort(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhost := NewVhost("example.com:8443", "", "", mockMiddleware, mockErrorHandler)
+	vhost.Aliases = []string{"www.example.com", "admin.example.com:9000"}
+	assert.Nil(t, vhosts.Add(vhost))
+
+	// the port of the hostname binds the aliases too, the port of an alias only the alias
+	for hostname, found := range map[string]bool{
+		"example.com:8443":       true,
+		"www.example.com:8443":   true,
+		"admin.example.com:8443": true,
+		"admin.example.com:9000": true,
+		"example.com:80":         false,
+		"www.example.com:80":     false,
+		"example.com:9000":       false,
+		"www.example.com":        false,
+	} {
+		_, ok := vhosts.Get(hostname)
+		assert.Equal(t, found, ok, hostname)
+	}
+}
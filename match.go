@@ -0,0 +1,119 @@
+package vhosts
+
+import (
+	"errors"
+	"strings"
+)
+
+// HostMatch describes how a request hostname was resolved to a vhost
+type HostMatch struct {
+	Hostname string            // hostname is the normalized request hostname
+	Port     string            // port is the port the vhost was matched on, empty if it matched a vhost without ports
+	Pattern  string            // pattern is the normalized hostname or alias ( or wildcard, template or regex ) that matched
+	Wildcard []string          // wildcard holds the labels matched by a wildcard pattern ( nil for exact matches )
+	Params   map[string]string // params holds the parameters captured by a host template or regex
+}
+
+// Match returns the vhost for the given hostname and how it was matched.
+//
+// An exact hostname always wins. Host templates ( {tenant}.example.com ) and raw
+// regexes ( ~^(?P<tenant>[a-z]+)\.example\.com$ ) come next and are tried in the
+// order the vhosts were added, they are matched against the normalized hostname.
+// Wildcards are tried last, from the longest suffix to the shortest, and for the
+// same suffix a single label wildcard ( *.example.com ) wins over a multi label
+// one ( **.example.com ).
+// "*.example.com" matches exactly one label in front of example.com while
+// "**.example.com" matches one or more labels, neither matches example.com itself.
+//
+// If the hostname carries a port ( example.com:8443 ) the vhosts bound to that port
+// are tried first, the vhosts without ports act as a fallback for any port.
+//
+// Match never takes a lock, it reads the routing table published by the last mutation.
+func (v *Vhosts) Match(hostname string) (Vhost, HostMatch, bool) {
+	vhost, match, ok := v.routing().match(hostname)
+	if !ok {
+		return Vhost{}, match, false
+	}
+	return *vhost, match, true
+}
+
+// match returns the vhost matching the given hostname
+func (t *routingTable) match(hostname string) (*Vhost, HostMatch, bool) {
+	host, port := splitHostPort(strings.TrimSpace(hostname))
+	key := NormalizeHostname(host)
+
+	// vhosts bound to the port first
+	if port != "" {
+		if vhost, match, ok := t.matchPort(key, portSuffix(port)); ok {
+			match.Port = port
+			return vhost, match, true
+		}
+	}
+	return t.matchPort(key, "")
+}
+
+// matchPort returns the vhost matching the normalized hostname and port suffix ( :8443 or empty )
+func (t *routingTable) matchPort(key, port string) (*Vhost, HostMatch, bool) {
+	match := HostMatch{Hostname: key}
+
+	// exact match
+	if vhost, ok := t.index.get(key + port); ok {
+		match.Pattern = key
+		return vhost, match, true
+	}
+
+	// host templates and regexes
+	for _, pattern := range t.patterns {
+		if pattern.port != port {
+			continue
+		}
+		if params, ok := matchParams(pattern.re, key); ok {
+			match.Pattern = pattern.name
+			match.Params = params
+			return pattern.vhost, match, true
+		}
+	}
+
+	// walk the suffixes from the longest to the shortest
+	for dot := strings.IndexByte(key, '.'); dot > 0; {
+		suffix := key[dot+1:]
+
+		// the single label wildcard only applies to the longest suffix
+		var vhost *Vhost
+		ok := false
+		if !strings.Contains(key[:dot], ".") {
+			vhost, ok = t.wildcards.get(suffix + port)
+			match.Pattern = "*." + suffix
+		}
+		if !ok {
+			vhost, ok = t.deepWildcards.get(suffix + port)
+			match.Pattern = "**." + suffix
+		}
+		if ok {
+			match.Wildcard = strings.Split(key[:dot], ".")
+			return vhost, match, true
+		}
+
+		next := strings.IndexByte(suffix, '.')
+		if next < 0 {
+			break
+		}
+		dot += next + 1
+	}
+
+	return nil, match, false
+}
+
+// validateWildcard checks that a wildcard is only used as the leftmost label of the normalized hostname
+func validateWildcard(hostname string) error {
+	rest := hostname
+	if strings.HasPrefix(rest, "**.") {
+		rest = rest[3:]
+	} else if strings.HasPrefix(rest, "*.") {
+		rest = rest[2:]
+	}
+	if rest == "" || strings.Contains(rest, "*") {
+		return errors.New("invalid wildcard hostname")
+	}
+	return nil
+}
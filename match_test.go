@@ -0,0 +1,134 @@
+package vhosts
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVhosts_Match_Wildcard(t *testing.T) {
+	vhosts := &Vhosts{}
+	assert.Nil(t, vhosts.Add(NewVhost("*.example.com", "", "single", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.Add(NewVhost("**.example.com", "", "deep", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.Add(NewVhost("**.eu.example.com", "", "deep-eu", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.Add(NewVhost("www.example.com", "", "exact", mockMiddleware, mockErrorHandler)))
+
+	tests := []struct {
+		hostname  string
+		websiteID string
+		wildcard  []string
+	}{
+		{"www.example.com", "exact", nil},
+		{"shop.example.com", "single", []string{"shop"}},
+		{"a.b.example.com", "deep", []string{"a", "b"}},
+		{"shop.eu.example.com", "deep-eu", []string{"shop"}},
+		{"a.b.eu.example.com", "deep-eu", []string{"a", "b"}},
+		{"SHOP.Example.com", "single", []string{"shop"}},
+	}
+	for _, tt := range tests {
+		vhost, match, ok := vhosts.Match(tt.hostname)
+		assert.True(t, ok, tt.hostname)
+		assert.Equal(t, tt.websiteID, vhost.WebsiteID, tt.hostname)
+		assert.Equal(t, tt.wildcard, match.Wildcard, tt.hostname)
+	}
+
+	// a wildcard doesn't match the bare suffix
+	_, _, ok := vhosts.Match("example.com")
+	assert.False(t, ok)
+	_, _, ok = vhosts.Match("example.org")
+	assert.False(t, ok)
+
+	// the wildcard vhost is removed by its pattern
+	assert.Nil(t, vhosts.Remove("*.example.com"))
+	vhost, _, ok := vhosts.Match("shop.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "deep", vhost.WebsiteID)
+}
+
+func TestVhosts_Add_InvalidWildcard(t *testing.T) {
+	vhosts := &Vhosts{}
+	for _, hostname := range []string{"shop.*.example.com", "*example.com", "*.", "***.example.com"} {
+		assert.NotNil(t, vhosts.Add(NewVhost(hostname, "", "", mockMiddleware, mockErrorHandler)), hostname)
+	}
+}
+
+func TestXVhost_WildcardLocals(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.Add(NewVhost("**.example.com", "", "", func(c *fiber.Ctx) error {
+		labels := c.Locals("vhost.wildcard").([]string)
+		return c.SendString(c.Locals("vhost.pattern").(string) + " " + strings.Join(labels, ","))
+	}, mockErrorHandler))
+
+	app := fiber.New()
+	app.Use(XVhost(vhosts))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "http://a.b.example.com/", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "**.example.com a,b", string(body))
+}
+
+func TestVhosts_Match_Port(t *testing.T) {
+	vhosts := &Vhosts{}
+	assert.Nil(t, vhosts.Add(NewVhost("example.com", "", "any", mockMiddleware, mockErrorHandler)))
+	admin := NewVhost("example.com", "", "admin", mockMiddleware, mockErrorHandler)
+	admin.Ports = []int{8443, 9443}
+	assert.Nil(t, vhosts.Add(admin))
+	assert.Nil(t, vhosts.Add(NewVhost("*.example.com:8443", "", "wildcard-admin", mockMiddleware, mockErrorHandler)))
+
+	// the same hostname and port can't be added twice
+	assert.NotNil(t, vhosts.Add(NewVhost("example.com:9443", "", "", mockMiddleware, mockErrorHandler)))
+
+	tests := []struct {
+		hostname  string
+		websiteID string
+		port      string
+	}{
+		{"example.com", "any", ""},
+		{"example.com:80", "any", ""},
+		{"example.com:8443", "admin", "8443"},
+		{"EXAMPLE.com.:9443", "admin", "9443"},
+		{"shop.example.com:8443", "wildcard-admin", "8443"},
+	}
+	for _, tt := range tests {
+		vhost, match, ok := vhosts.Match(tt.hostname)
+		assert.True(t, ok, tt.hostname)
+		assert.Equal(t, tt.websiteID, vhost.WebsiteID, tt.hostname)
+		assert.Equal(t, tt.port, match.Port, tt.hostname)
+	}
+
+	// the wildcard is bound to 8443 only
+	_, _, ok := vhosts.Match("shop.example.com")
+	assert.False(t, ok)
+
+	// removing by host:port removes the port bound vhost only
+	assert.Nil(t, vhosts.Remove("example.com:9443"))
+	vhost, _, _ := vhosts.Match("example.com:8443")
+	assert.Equal(t, "any", vhost.WebsiteID)
+}
+
+func TestXVhost_Port(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.Add(NewVhost("example.com", "", "", mockMiddleware, mockErrorHandler))
+	vhosts.Add(NewVhost("example.com:8443", "", "", func(c *fiber.Ctx) error {
+		return c.SendString("admin " + c.Locals("vhost.port").(string))
+	}, mockErrorHandler))
+
+	app := fiber.New()
+	app.Use(XVhost(vhosts))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "http://example.com:8443/", nil))
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "admin 8443", string(body))
+
+	resp, err = app.Test(httptest.NewRequest("GET", "http://example.com/", nil))
+	assert.Nil(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	assert.Equal(t, "Hello, World!", string(body))
+}
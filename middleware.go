@@ -1,8 +1,10 @@
 package vhosts
 
 import (
+	"net"
 	"reflect"
 	"runtime"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
@@ -14,25 +16,63 @@ type FiberHandler func(*fiber.Ctx) error
 // FiberErrorHandler is the error handler for the vhost middleware
 type FiberErrorHandler func(*fiber.Ctx, error) error
 
-// VhostsHandler is the handler for the vhosts middleware
+// noVhosts is the empty vhosts list served when neither Config.Registry nor the package level vhosts list is set
+var noVhosts = &Vhosts{}
+
+// XVhost is the handler for the vhosts middleware serving the vhosts of the given registry
+func XVhost(vh *Vhosts, config ...Config) func(c *fiber.Ctx) error {
+	cfg := configDefault(config...)
+	cfg.Registry = vh
+	return New(cfg)
+}
+
+// New creates a new vhosts middleware handler
+func New(config ...Config) fiber.Handler {
+	cfg := configDefault(config...)
 
-func XVhost(vh *Vhosts) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
-		// debug("vhosts middleware")
-		// log.Debugf("vhosts middleware %s", c.Hostname())
+		// Don't execute middleware if Next returns true
+		if cfg.Next != nil && cfg.Next(c) {
+			return c.Next()
+		}
 
-		// log the number of vhosts
-		// log.Debugf("vhosts count %d", len(vh.Vhosts))
+		// Use the package level vhosts list if no registry is configured
+		vh := cfg.Registry
+		if vh == nil {
+			vh = GetVhosts()
+		}
+		if vh == nil {
+			// the package level vhosts list isn't set either, every hostname is unknown
+			log.Debugf("vhosts middleware has no registry")
+			vh = noVhosts
+		}
 
 		// Get the hostname from the request
-		hostname := c.Hostname()
+		hostname := cfg.HostExtractor(c)
 
-		// Get the vhost with the given hostname
-		fVhost, ok := vh.Get(hostname)
+		// Get the vhost with the given hostname, the port the request was received on picks port bound vhosts
+		fVhost, match, ok := vh.Match(withListenPort(c, hostname))
 		if !ok {
 			log.Debugf("vhost not found for hostname %s", hostname)
-			// Return a 404 if the vhost doesn't exist
-			return c.SendStatus(404)
+
+			// Apply the unknown host policy
+			switch cfg.UnknownHost {
+			case UnknownHostDefault:
+				fVhost, match, ok = vh.Match(cfg.DefaultHostname)
+			case UnknownHostNext:
+				return c.Next()
+			case UnknownHostClose:
+				closeConnection(c)
+				return nil
+			}
+
+			// Render the not found response ( or a 404 ) if the vhost doesn't exist
+			if !ok {
+				if cfg.NotFoundHandler != nil {
+					return cfg.NotFoundHandler(c)
+				}
+				return c.SendStatus(404)
+			}
 		}
 
 		log.Debugf("vhost found for hostname %s", hostname)
@@ -44,12 +84,73 @@ func XVhost(vh *Vhosts) func(c *fiber.Ctx) error {
 		// print function name
 		log.Debugf("vhost handler %s", runtime.FuncForPC(reflect.ValueOf(fVhost.Handler).Pointer()).Name())
 
+		// Redirect to the canonical hostname of the vhost ( if it has a policy )
+		if redirected, err := canonicalRedirect(c, hostname, fVhost, match); redirected {
+			return err
+		}
+
 		// Set some values on the context
-		c.Locals("vhost.hostname", hostname)                // Hostname
-		c.Locals("vhost.websiteID", fVhost.WebsiteID)       // Website ID
-		c.Locals("vhost.errorHandler", fVhost.ErrorHandler) // Error Handler
+		c.Locals(cfg.LocalsPrefix+"hostname", hostname)                // Hostname
+		c.Locals(cfg.LocalsPrefix+"websiteID", fVhost.WebsiteID)       // Website ID
+		c.Locals(cfg.LocalsPrefix+"errorHandler", fVhost.ErrorHandler) // Error Handler
+		c.Locals(cfg.LocalsPrefix+"pattern", match.Pattern)            // Matched hostname or wildcard pattern
+		c.Locals(cfg.LocalsPrefix+"wildcard", match.Wildcard)          // Labels matched by the wildcard ( nil for exact matches )
+		c.Locals(cfg.LocalsPrefix+"params", match.Params)              // Parameters captured by a host template or regex ( see HostParam )
+		c.Locals(cfg.LocalsPrefix+"port", match.Port)                  // Port the vhost is bound to ( empty for vhosts without ports )
+		c.Locals(paramsKey{}, match.Params)                            // Parameters for HostParam regardless of the prefix
+
+		// Route the request to the handler tag of the longest matching path prefix ( if any )
+		fVhost, route, err := vh.route(c, fVhost)
+		if err != nil {
+			return handleError(c, vh, fVhost, cfg, err)
+		}
+		if route != nil {
+			c.Locals(cfg.LocalsPrefix+"prefix", route.Prefix) // Path prefix of the matched route
+		}
 
-		// Call the vhost's middleware
-		return fVhost.Handler(c)
+		// A quarantined vhost isn't served until the quarantine ends
+		if cfg.QuarantineThreshold > 0 && vh.isQuarantined(vhostKey(fVhost)) {
+			return cfg.QuarantineHandler(c)
+		}
+
+		// Call the vhost's handler behind its middleware chain
+		if cfg.Recover {
+			err = vh.serveRecover(c, fVhost, cfg, hostname)
+		} else {
+			err = vh.serve(c, fVhost)
+		}
+		if err != nil {
+			return handleError(c, vh, fVhost, cfg, err)
+		}
+		return nil
+	}
+}
+
+// handleError routes an error returned for the vhost to the error handler of the vhost, falling back to
+// the default error handler of the registry and then to the error handler of the config. Without any
+// of them the error is returned to fiber.
+func handleError(c *fiber.Ctx, vh *Vhosts, vhost Vhost, cfg Config, err error) error {
+	if vhost.ErrorHandler != nil {
+		return vhost.ErrorHandler(c, err)
+	}
+	if errorHandler, ok := vh.DefaultErrorHandler(); ok {
+		return errorHandler(c, err)
+	}
+	if cfg.ErrorHandler != nil {
+		return cfg.ErrorHandler(c, err)
+	}
+	return err
+}
+
+// withListenPort appends the port the request was received on to a hostname without an explicit port
+func withListenPort(c *fiber.Ctx, hostname string) string {
+	host, port := splitHostPort(hostname)
+	if port != "" {
+		return hostname
+	}
+	addr, ok := c.Context().LocalAddr().(*net.TCPAddr)
+	if !ok || addr.Port == 0 {
+		return hostname
 	}
+	return net.JoinHostPort(host, strconv.Itoa(addr.Port))
 }
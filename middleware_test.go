@@ -1,12 +1,16 @@
 package vhosts
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
 	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
 )
 
 // Test the VhostsHandler middleware
@@ -54,6 +58,169 @@ func TestVhostsHandler(t *testing.T) {
 
 }
 
+// Test the New middleware constructor options
+func TestNew_Config(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.Add(NewVhost("test.com", "", "42", func(c *fiber.Ctx) error {
+		if c.Path() == "/fail" {
+			return fiber.ErrTeapot
+		}
+		return c.SendString(c.Locals("site.websiteID").(string) + " " + c.Locals("site.hostname").(string))
+	}, nil))
+
+	app := fiber.New()
+	app.Use(New(Config{
+		Registry: vhosts,
+		Next: func(c *fiber.Ctx) bool {
+			return c.Path() == "/skip"
+		},
+		HostExtractor: func(c *fiber.Ctx) string {
+			return c.Get("X-Site")
+		},
+		LocalsPrefix: "site.",
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			return c.Status(500).SendString("handled " + err.Error())
+		},
+	}))
+	app.Get("/skip", func(c *fiber.Ctx) error {
+		return c.SendString("skipped")
+	})
+
+	tests := []struct {
+		path   string
+		site   string
+		status int
+		body   string
+	}{
+		{"/", "test.com", 200, "42 test.com"},
+		{"/", "", 404, "Not Found"},
+		{"/skip", "", 200, "skipped"},
+		{"/fail", "test.com", 500, "handled I'm a teapot"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://other.com"+tt.path, nil)
+		req.Header.Set("X-Site", tt.site)
+		resp, err := app.Test(req)
+		assert.Equal(t, nil, err, tt.path)
+		assert.Equal(t, tt.status, resp.StatusCode, tt.path)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, tt.body, string(body), tt.path)
+	}
+}
+
+// Test that New serves the package level vhosts list without a registry
+func TestNew_DefaultRegistry(t *testing.T) {
+	Vhs = &Vhosts{}
+	Vhs.Add(NewVhost("test.com", "", "", mockMiddleware, mockErrorHandler))
+
+	app := fiber.New()
+	app.Use(New())
+
+	resp, err := app.Test(httptest.NewRequest("GET", "http://test.com/", nil))
+	assert.Equal(t, nil, err, "app.Test(req)")
+	assert.Equal(t, 200, resp.StatusCode, "Status code")
+}
+
+// Test that errors of the vhost handler go to the vhost error handler, the registry default or the config error handler
+func TestXVhost_ErrorHandler(t *testing.T) {
+	failing := func(c *fiber.Ctx) error {
+		if c.Path() == "/teapot" {
+			return fiber.NewError(fiber.StatusTeapot, "short and stout")
+		}
+		return errors.New("plain error")
+	}
+	vhostErrorHandler := func(c *fiber.Ctx, err error) error {
+		var e *fiber.Error
+		if errors.As(err, &e) {
+			return c.Status(e.Code).SendString("vhost " + e.Message)
+		}
+		return c.Status(503).SendString("vhost " + err.Error())
+	}
+	registryErrorHandler := func(c *fiber.Ctx, err error) error {
+		return c.Status(502).SendString("registry " + err.Error())
+	}
+	configErrorHandler := func(c *fiber.Ctx, err error) error {
+		return c.Status(501).SendString("config " + err.Error())
+	}
+
+	tests := []struct {
+		name                 string
+		vhostErrorHandler    FiberErrorHandler
+		registryErrorHandler FiberErrorHandler
+		configErrorHandler   fiber.ErrorHandler
+		path                 string
+		status               int
+		body                 string
+	}{
+		{"vhost", vhostErrorHandler, registryErrorHandler, configErrorHandler, "/", 503, "vhost plain error"},
+		{"vhost fiber error", vhostErrorHandler, nil, nil, "/teapot", 418, "vhost short and stout"},
+		{"registry", nil, registryErrorHandler, configErrorHandler, "/", 502, "registry plain error"},
+		{"config", nil, nil, configErrorHandler, "/", 501, "config plain error"},
+		{"fiber", nil, nil, nil, "/", 500, "plain error"},
+		{"fiber error", nil, nil, nil, "/teapot", 418, "short and stout"},
+	}
+	for _, tt := range tests {
+		vhosts := &Vhosts{}
+		vhosts.Add(NewVhost("test.com", "", "", failing, tt.vhostErrorHandler))
+		vhosts.SetDefaultErrorHandler(tt.registryErrorHandler)
+
+		app := fiber.New()
+		app.Use(XVhost(vhosts, Config{ErrorHandler: tt.configErrorHandler}))
+
+		resp, err := app.Test(httptest.NewRequest("GET", "http://test.com"+tt.path, nil))
+		assert.Equal(t, nil, err, tt.name)
+		assert.Equal(t, tt.status, resp.StatusCode, tt.name)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, tt.body, string(body), tt.name)
+	}
+}
+
+// Test that errors returned inside the middleware chain reach the vhost error handler
+func TestXVhost_ErrorHandler_Chain(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.Add(NewVhost("test.com", "", "", mockMiddleware, func(c *fiber.Ctx, err error) error {
+		return c.Status(401).SendString("vhost " + err.Error())
+	}))
+	vhosts.UseVhost("test.com", func(c *fiber.Ctx) error {
+		return fiber.ErrUnauthorized
+	})
+
+	app := fiber.New()
+	app.Use(XVhost(vhosts))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "http://test.com/", nil))
+	assert.Equal(t, nil, err, "app.Test(req)")
+	assert.Equal(t, 401, resp.StatusCode, "Status code")
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "vhost Unauthorized", string(body), "Response body")
+}
+
+// Benchmark the XVhost middleware with 10k vhosts registered
+func BenchmarkXVhost(b *testing.B) {
+	vhosts := &Vhosts{}
+	for i := 0; i < 10000; i++ {
+		vhosts.Add(NewVhost(fmt.Sprintf("host%d.example.com", i), "", "", mockMiddleware, mockErrorHandler))
+	}
+	handler := XVhost(vhosts)
+
+	// keep the debug logging out of the measurement
+	log.SetLevel(log.LevelInfo)
+	defer log.SetLevel(log.LevelTrace)
+
+	app := fiber.New()
+	c := app.AcquireCtx(&fasthttp.RequestCtx{})
+	defer app.ReleaseCtx(c)
+	c.Request().SetHost("host9999.example.com")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := handler(c); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func mockMiddleware(c *fiber.Ctx) error {
 	return c.SendString("Hello, World!")
 }
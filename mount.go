@@ -0,0 +1,74 @@
+package vhosts
+
+import (
+	"errors"
+	"maps"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// Mount attaches a fiber app to the vhost with the given hostname or alias. Requests for the vhost
+// are dispatched into the router of the app, with its own routes, 404s and error handler, instead
+// of the handler of the vhost. The middleware chain of the vhost still runs in front of the app.
+// Routes must be registered before the app is mounted, like before calling app.Listen, and
+// the path routes of the vhost ( see AddRoute ) don't apply while an app is mounted.
+func (v *Vhosts) Mount(hostname string, app *fiber.App) error {
+	if app == nil {
+		return errors.New("app is nil")
+	}
+	handler := app.Handler()
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	i, ok := v.index[indexKey(hostname)]
+	if !ok {
+		return errors.New("vhost not found")
+	}
+	if v.apps == nil {
+		v.apps = make(map[string]fasthttp.RequestHandler)
+	}
+	v.apps[vhostKey(v.Vhosts[i])] = handler
+	v.publish(func(table *routingTable) {
+		table.apps = maps.Clone(v.apps)
+	})
+	return nil
+}
+
+// MountRouter creates a new fiber app with the given config, lets register add its routes and mounts it on the vhost
+func (v *Vhosts) MountRouter(hostname string, register func(router fiber.Router), config ...fiber.Config) error {
+	app := fiber.New(config...)
+	register(app)
+	return v.Mount(hostname, app)
+}
+
+// Unmount detaches the fiber app from the vhost with the given hostname or alias
+func (v *Vhosts) Unmount(hostname string) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	i, ok := v.index[indexKey(hostname)]
+	if !ok {
+		return errors.New("vhost not found")
+	}
+	key := vhostKey(v.Vhosts[i])
+	if _, ok := v.apps[key]; !ok {
+		return errors.New("no app mounted")
+	}
+	delete(v.apps, key)
+	v.publish(func(table *routingTable) {
+		table.apps = maps.Clone(v.apps)
+	})
+	return nil
+}
+
+// mountedHandler returns a handler dispatching into the app mounted on the vhost, or false if there is none
+func (v *Vhosts) mountedHandler(vhost Vhost) (FiberHandler, bool) {
+	handler, ok := v.routing().apps[vhostKey(vhost)]
+	if !ok {
+		return nil, false
+	}
+	return func(c *fiber.Ctx) error {
+		handler(c.Context())
+		return nil
+	}, true
+}
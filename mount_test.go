@@ -0,0 +1,94 @@
+package vhosts
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVhosts_Mount(t *testing.T) {
+	tenant := fiber.New(fiber.Config{
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			var e *fiber.Error
+			if errors.As(err, &e) {
+				return c.Status(e.Code).SendString("tenant " + e.Message)
+			}
+			return c.Status(599).SendString("tenant error")
+		},
+	})
+	tenant.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("tenant home " + c.Locals("vhost.websiteID").(string))
+	})
+	tenant.Get("/users/:id", func(c *fiber.Ctx) error {
+		return c.SendString("user " + c.Params("id"))
+	})
+	tenant.Get("/fail", func(c *fiber.Ctx) error {
+		return errors.New("boom")
+	})
+
+	vhosts := &Vhosts{}
+	vhost := NewVhost("tenant.com", "", "7", nil, nil)
+	vhost.Aliases = []string{"www.tenant.com"}
+	vhosts.Add(vhost)
+	vhosts.Add(NewVhost("other.com", "", "", mockMiddleware, mockErrorHandler))
+	assert.Nil(t, vhosts.Mount("www.tenant.com", tenant))
+	assert.NotNil(t, vhosts.Mount("missing.com", tenant))
+	assert.NotNil(t, vhosts.Mount("other.com", nil))
+
+	// middleware of the vhost runs in front of the mounted app
+	vhosts.UseVhost("tenant.com", func(c *fiber.Ctx) error {
+		c.Set("X-Tenant", "yes")
+		return c.Next()
+	})
+
+	app := fiber.New()
+	app.Use(XVhost(vhosts))
+
+	tests := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"http://tenant.com/", 200, "tenant home 7"},
+		{"http://tenant.com/users/42", 200, "user 42"},
+		{"http://tenant.com/missing", 404, "tenant Cannot GET /missing"},
+		{"http://tenant.com/fail", 599, "tenant error"},
+		{"http://other.com/users/42", 200, "Hello, World!"},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", tt.url, nil))
+		assert.Nil(t, err, tt.url)
+		assert.Equal(t, tt.status, resp.StatusCode, tt.url)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, tt.body, string(body), tt.url)
+	}
+
+	resp, _ := app.Test(httptest.NewRequest("GET", "http://tenant.com/", nil))
+	assert.Equal(t, "yes", resp.Header.Get("X-Tenant"))
+
+	assert.Nil(t, vhosts.Unmount("tenant.com"))
+	assert.NotNil(t, vhosts.Unmount("tenant.com"))
+}
+
+func TestVhosts_MountRouter(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.Add(NewVhost("api.com", "", "", nil, nil))
+	assert.Nil(t, vhosts.MountRouter("api.com", func(router fiber.Router) {
+		v2 := router.Group("/api/v2")
+		v2.Get("/ping", func(c *fiber.Ctx) error {
+			return c.SendString("pong")
+		})
+	}))
+
+	app := fiber.New()
+	app.Use(XVhost(vhosts))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "http://api.com/api/v2/ping", nil))
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "pong", string(body))
+}
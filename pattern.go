@@ -0,0 +1,116 @@
+package vhosts
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// hostPattern is a compiled host template ( {tenant}.example.com ) or raw regex ( ~^(?P<tenant>[a-z]+)\.example\.com$ )
+type hostPattern struct {
+	re    *regexp.Regexp // re is the compiled pattern
+	name  string         // name is the normalized hostname ( or alias ) the pattern was compiled from
+	vhost *Vhost         // vhost is the vhost the pattern resolves to
+	port  string         // port is the port suffix ( :8443 ) the pattern is bound to, empty for any port
+}
+
+// isHostPattern reports whether the hostname is a host template or a raw regex
+func isHostPattern(hostname string) bool {
+	return strings.HasPrefix(hostname, "~") || strings.Contains(hostname, "{")
+}
+
+// compileHostPattern compiles a host template or a raw regex ( prefixed with ~ ) into a regexp.
+// Every {name} in a template matches a single label and is captured as the host parameter "name",
+// named groups of a raw regex are captured the same way.
+func compileHostPattern(hostname string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(hostname, "~") {
+		return regexp.Compile(hostname[1:])
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	rest := hostname
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			expr.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		expr.WriteString(regexp.QuoteMeta(rest[:open]))
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, errors.New("unclosed parameter in host template")
+		}
+		name := rest[open+1 : open+end]
+		if !isParamName(name) {
+			return nil, errors.New("invalid parameter name in host template")
+		}
+		expr.WriteString("(?P<" + name + ">[^.]+)")
+		rest = rest[open+end+1:]
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// isParamName reports whether name can be used as a host parameter name
+func isParamName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// matchParams returns the named captures of re in hostname, or false if it doesn't match
+func matchParams(re *regexp.Regexp, hostname string) (map[string]string, bool) {
+	submatches := re.FindStringSubmatch(hostname)
+	if submatches == nil {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			params[name] = submatches[i]
+		}
+	}
+	return params, true
+}
+
+// paramsKey is the locals key of the host parameters, independent of Config.LocalsPrefix
+type paramsKey struct{}
+
+// HostParams returns all the host parameters captured for the request
+func HostParams(c *fiber.Ctx) map[string]string {
+	params, _ := c.Locals(paramsKey{}).(map[string]string)
+	return params
+}
+
+// HostParam returns the host parameter with the given key, or the default value ( if any ) when it wasn't captured
+func HostParam(c *fiber.Ctx, key string, defaultValue ...string) string {
+	if value, ok := HostParams(c)[key]; ok {
+		return value
+	}
+	if len(defaultValue) > 0 {
+		return defaultValue[0]
+	}
+	return ""
+}
+
+// HostParamInt returns the host parameter with the given key converted to an integer
+func HostParamInt(c *fiber.Ctx, key string, defaultValue ...int) (int, error) {
+	value, err := strconv.Atoi(HostParam(c, key))
+	if err != nil {
+		if len(defaultValue) > 0 {
+			return defaultValue[0], nil
+		}
+		return 0, err
+	}
+	return value, nil
+}
@@ -0,0 +1,68 @@
+package vhosts
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVhosts_Match_Template(t *testing.T) {
+	vhosts := &Vhosts{}
+	assert.Nil(t, vhosts.Add(NewVhost("{tenant}.{region}.apps.example.com", "", "template", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.Add(NewVhost(`~^(?P<shop>[a-z]+)-(?P<id>[0-9]+)\.shops\.example\.com$`, "", "regex", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.Add(NewVhost("admin.eu.apps.example.com", "", "exact", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.Add(NewVhost("**.example.com", "", "wildcard", mockMiddleware, mockErrorHandler)))
+
+	vhost, match, ok := vhosts.Match("Acme.EU.apps.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "template", vhost.WebsiteID)
+	assert.Equal(t, map[string]string{"tenant": "acme", "region": "eu"}, match.Params)
+
+	vhost, match, ok = vhosts.Match("books-42.shops.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "regex", vhost.WebsiteID)
+	assert.Equal(t, map[string]string{"shop": "books", "id": "42"}, match.Params)
+
+	// exact beats templates, templates beat wildcards
+	vhost, _, _ = vhosts.Match("admin.eu.apps.example.com")
+	assert.Equal(t, "exact", vhost.WebsiteID)
+	vhost, match, _ = vhosts.Match("a.b.c.apps.example.com")
+	assert.Equal(t, "wildcard", vhost.WebsiteID)
+	assert.Nil(t, match.Params)
+}
+
+func TestVhosts_Add_InvalidPattern(t *testing.T) {
+	vhosts := &Vhosts{}
+	for _, hostname := range []string{"{tenant.example.com", "{}.example.com", "{ten-ant}.example.com", "~^(unclosed"} {
+		assert.NotNil(t, vhosts.Add(NewVhost(hostname, "", "", mockMiddleware, mockErrorHandler)), hostname)
+	}
+}
+
+func TestXVhost_HostParams(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.Add(NewVhost("{tenant}.{id}.example.com", "", "", func(c *fiber.Ctx) error {
+		id, err := HostParamInt(c, "id")
+		if err != nil {
+			return err
+		}
+		return c.SendString(fmt.Sprintf("%s %d %s", HostParam(c, "tenant"), id, HostParam(c, "region", "global")))
+	}, nil))
+
+	app := fiber.New()
+	app.Use(XVhost(vhosts))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "http://acme.7.example.com/", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "acme 7 global", string(body))
+
+	// a non numeric id is returned as error by HostParamInt
+	resp, err = app.Test(httptest.NewRequest("GET", "http://acme.seven.example.com/", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, 500, resp.StatusCode)
+}
@@ -0,0 +1,163 @@
+package vhosts
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TrustedProxyHostExtractor returns a HostExtractor honoring the RFC 7239 Forwarded and the
+// X-Forwarded-Host headers for requests from the given trusted proxies ( IPs or CIDRs ).
+// Forwarded wins over X-Forwarded-Host. Proxies append their values, so the headers are read from the right and
+// the value added by the nearest trusted proxy is used, hops that are trusted proxies themselves ( the for parameter
+// of Forwarded, X-Forwarded-For ) are skipped. Values the client sent in front of them are never used.
+// The forwarded protocol ( Forwarded proto or X-Forwarded-Proto ) of trusted proxies is used for canonical redirects.
+// For requests from any other peer the headers are ignored and the Host header is used, so clients
+// can't spoof the hostname by sending the headers themselves.
+func TrustedProxyHostExtractor(trustedProxies ...string) (HostExtractor, error) {
+	prefixes := make([]netip.Prefix, 0, len(trustedProxies))
+	for _, proxy := range trustedProxies {
+		prefix, err := parseTrustedProxy(proxy)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return func(c *fiber.Ctx) string {
+		host := string(c.Request().Host())
+		if !isTrustedProxy(c, prefixes) {
+			return host
+		}
+		if proto := forwardedProto(c, prefixes); proto != "" {
+			c.Locals(protoKey{}, proto)
+		}
+		if forwarded := forwardedHost(c.Get(fiber.HeaderForwarded), prefixes); forwarded != "" {
+			return forwarded
+		}
+		if forwarded := forwardedValue(c.Get(fiber.HeaderXForwardedHost), c.Get(fiber.HeaderXForwardedFor), prefixes); forwarded != "" {
+			return forwarded
+		}
+		return host
+	}, nil
+}
+
+// parseTrustedProxy parses an IP ( 10.0.0.1 ) or CIDR ( 10.0.0.0/8 ) into a prefix
+func parseTrustedProxy(proxy string) (netip.Prefix, error) {
+	proxy = strings.TrimSpace(proxy)
+	if strings.Contains(proxy, "/") {
+		prefix, err := netip.ParsePrefix(proxy)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(proxy)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// isTrustedProxy reports whether the peer of the request is one of the trusted proxies
+func isTrustedProxy(c *fiber.Ctx, prefixes []netip.Prefix) bool {
+	addr, ok := netip.AddrFromSlice(c.Context().RemoteIP())
+	return ok && isTrustedAddr(addr, prefixes)
+}
+
+// isTrustedAddr reports whether the address is one of the trusted proxies
+func isTrustedAddr(addr netip.Addr, prefixes []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTrustedNode reports whether the node of a Forwarded for parameter or a X-Forwarded-For value
+// ( 10.0.0.1, "10.0.0.1:8080", "[2001:db8::1]:4711" ) is one of the trusted proxies, obfuscated
+// and unknown nodes never are
+func isTrustedNode(node string, prefixes []netip.Prefix) bool {
+	node = strings.Trim(strings.TrimSpace(node), `"`)
+	if addrPort, err := netip.ParseAddrPort(node); err == nil {
+		return isTrustedAddr(addrPort.Addr(), prefixes)
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(node, "["), "]"))
+	return err == nil && isTrustedAddr(addr, prefixes)
+}
+
+// protoKey is the locals key of the protocol forwarded by a trusted proxy
+type protoKey struct{}
+
+// forwardedHost returns the host parameter of a RFC 7239 Forwarded header added by the nearest trusted proxy
+func forwardedHost(header string, prefixes []netip.Prefix) string {
+	return forwardedParam(header, "host", prefixes)
+}
+
+// forwardedParam returns the given parameter of a RFC 7239 Forwarded header added by the nearest trusted proxy.
+// The elements are read from the right ( the last one was appended by the peer ), the elements of hops whose
+// for parameter is a trusted proxy as well are skipped, the value of the first untrusted hop wins.
+func forwardedParam(header, name string, prefixes []netip.Prefix) string {
+	elements := strings.Split(header, ",")
+	value := ""
+	for i := len(elements) - 1; i >= 0; i-- {
+		if param := elementParam(elements[i], name); param != "" {
+			value = param
+		}
+		if !isTrustedNode(elementParam(elements[i], "for"), prefixes) {
+			break
+		}
+	}
+	return value
+}
+
+// elementParam returns the given parameter of a single element of a RFC 7239 Forwarded header
+func elementParam(element, name string) string {
+	for _, pair := range strings.Split(element, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && strings.EqualFold(key, name) {
+			return strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return ""
+}
+
+// forwardedValue returns the value of a comma separated X-Forwarded header added by the nearest trusted proxy.
+// The values are read from the right and paired with the X-Forwarded-For addresses, the values of hops that are
+// trusted proxies as well are skipped like in forwardedParam.
+func forwardedValue(header, forwardedFor string, prefixes []netip.Prefix) string {
+	values, nodes := strings.Split(header, ","), strings.Split(forwardedFor, ",")
+	value := ""
+	for i := 1; i <= len(values); i++ {
+		if v := strings.TrimSpace(values[len(values)-i]); v != "" {
+			value = v
+		}
+		if i > len(nodes) || !isTrustedNode(nodes[len(nodes)-i], prefixes) {
+			break
+		}
+	}
+	return value
+}
+
+// forwardedProto returns the protocol ( http or https ) forwarded by the nearest trusted proxy, Forwarded wins over
+// X-Forwarded-Proto
+func forwardedProto(c *fiber.Ctx, prefixes []netip.Prefix) string {
+	proto := forwardedParam(c.Get(fiber.HeaderForwarded), "proto", prefixes)
+	if proto == "" {
+		proto = forwardedValue(c.Get(fiber.HeaderXForwardedProto), c.Get(fiber.HeaderXForwardedFor), prefixes)
+	}
+	proto = strings.ToLower(proto)
+	if proto != "http" && proto != "https" {
+		return ""
+	}
+	return proto
+}
+
+// requestProtocol returns the protocol forwarded by a trusted proxy ( see TrustedProxyHostExtractor ) or the
+// protocol of the request
+func requestProtocol(c *fiber.Ctx) string {
+	if proto, ok := c.Locals(protoKey{}).(string); ok {
+		return proto
+	}
+	return c.Protocol()
+}
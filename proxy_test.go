@@ -0,0 +1,94 @@
+package vhosts
+
+import (
+	"io"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustedProxyHostExtractor(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.Add(NewVhost("real.com", "", "", func(c *fiber.Ctx) error {
+		return c.SendString("real")
+	}, mockErrorHandler))
+	vhosts.Add(NewVhost("proxy.internal", "", "", func(c *fiber.Ctx) error {
+		return c.SendString("proxy")
+	}, mockErrorHandler))
+
+	// requests made by app.Test come from 0.0.0.0
+	trusted, err := TrustedProxyHostExtractor("0.0.0.0", "10.0.0.0/8")
+	assert.Nil(t, err)
+	untrusted, err := TrustedProxyHostExtractor("192.168.0.0/16", "fd00::/8")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name      string
+		extractor HostExtractor
+		headers   map[string]string
+		body      string
+	}{
+		{"trusted x-forwarded-host", trusted, map[string]string{"X-Forwarded-Host": "real.com"}, "real"},
+		{"trusted forwarded", trusted, map[string]string{"Forwarded": `for=1.2.3.4;host="real.com";proto=https`}, "real"},
+		// the value appended by the proxy wins over the values sent by the client
+		{"spoofed x-forwarded-host", trusted, map[string]string{"X-Forwarded-Host": "evil.com, real.com"}, "real"},
+		{"spoofed forwarded", trusted, map[string]string{"Forwarded": "host=evil.com, for=1.2.3.4;host=real.com"}, "real"},
+		// the hops of trusted proxies are skipped
+		{"chained x-forwarded-host", trusted, map[string]string{"X-Forwarded-Host": "evil.com, real.com, proxy.internal", "X-Forwarded-For": "6.6.6.6, 1.2.3.4, 10.0.0.1"}, "real"},
+		{"chained forwarded", trusted, map[string]string{"Forwarded": `host=evil.com, for=1.2.3.4;host=real.com, for="10.0.0.1:8080";host=proxy.internal`}, "real"},
+		{"untrusted hop", trusted, map[string]string{"Forwarded": "for=1.2.3.4;host=real.com, for=6.6.6.6;host=proxy.internal"}, "proxy"},
+		{"hop without host", trusted, map[string]string{"Forwarded": "for=1.2.3.4;host=real.com, for=10.0.0.1"}, "real"},
+		{"trusted forwarded wins", trusted, map[string]string{"Forwarded": "host=real.com", "X-Forwarded-Host": "other.com"}, "real"},
+		{"trusted without headers", trusted, nil, "proxy"},
+		{"untrusted x-forwarded-host", untrusted, map[string]string{"X-Forwarded-Host": "real.com"}, "proxy"},
+		{"untrusted forwarded", untrusted, map[string]string{"Forwarded": "host=real.com"}, "proxy"},
+	}
+	for _, tt := range tests {
+		app := fiber.New()
+		app.Use(New(Config{Registry: vhosts, HostExtractor: tt.extractor}))
+
+		req := httptest.NewRequest("GET", "http://proxy.internal/", nil)
+		for key, value := range tt.headers {
+			req.Header.Set(key, value)
+		}
+		resp, err := app.Test(req)
+		assert.Nil(t, err, tt.name)
+		assert.Equal(t, 200, resp.StatusCode, tt.name)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, tt.body, string(body), tt.name)
+	}
+}
+
+func TestTrustedProxyHostExtractor_InvalidProxy(t *testing.T) {
+	for _, proxy := range []string{"10.0.0.0/33", "not-an-ip", ""} {
+		_, err := TrustedProxyHostExtractor(proxy)
+		assert.NotNil(t, err, proxy)
+	}
+}
+
+func TestForwardedHost(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")}
+	assert.Equal(t, "example.com", forwardedHost("host=example.com", trusted))
+	assert.Equal(t, "example.com:8443", forwardedHost(`For="[2001:db8::1]";Host="example.com:8443"`, trusted))
+	assert.Equal(t, "example.com", forwardedHost(`host=evil.com, for="[2001:db8::1]:4711";host=example.com`, nil))
+	assert.Equal(t, "example.com", forwardedHost(`for=1.2.3.4;host=example.com, for="[2001:db8::1]:4711"`, trusted))
+	assert.Equal(t, "", forwardedHost("for=1.2.3.4;host=example.com, for=_hidden", trusted))
+	assert.Equal(t, "", forwardedHost("", trusted))
+}
+
+func TestForwardedParam(t *testing.T) {
+	assert.Equal(t, "https", forwardedParam("host=example.com;Proto=https", "proto", nil))
+	assert.Equal(t, "http", forwardedParam("proto=https, for=1.2.3.4;proto=http", "proto", nil))
+	assert.Equal(t, "", forwardedParam("proto=https, for=1.2.3.4", "proto", nil))
+}
+
+func TestForwardedValue(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	assert.Equal(t, "b.com", forwardedValue("a.com, b.com", "", trusted))
+	assert.Equal(t, "a.com", forwardedValue("a.com, b.com", "1.2.3.4, 10.0.0.1", trusted))
+	assert.Equal(t, "b.com", forwardedValue("a.com, b.com", "10.0.0.2, 1.2.3.4", trusted))
+	assert.Equal(t, "", forwardedValue("", "10.0.0.1", trusted))
+}
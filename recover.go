@@ -0,0 +1,148 @@
+package vhosts
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// PanicError is the error a recovered panic of a vhost is converted to
+type PanicError struct {
+	Hostname  string      // hostname is the hostname of the request
+	WebsiteID string      // websiteID is the websiteID of the vhost that panicked
+	Value     interface{} // value is the value passed to panic
+	Stack     []byte      // stack is the stack trace of the panic
+}
+
+// Error returns the error message of the panic
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("vhost %s ( website %s ) panicked: %v", e.Hostname, e.WebsiteID, e.Value)
+}
+
+// serveRecover calls serve and converts a panic of the vhost into a *PanicError, recording it for the quarantine
+func (v *Vhosts) serveRecover(c *fiber.Ctx, vhost Vhost, cfg Config, hostname string) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		panicErr := &PanicError{
+			Hostname:  hostname,
+			WebsiteID: vhost.WebsiteID,
+			Value:     r,
+			Stack:     debug.Stack(),
+		}
+		log.Errorf("%s\n%s", panicErr.Error(), panicErr.Stack)
+
+		if cfg.QuarantineThreshold > 0 && v.recordPanic(vhostKey(vhost), cfg) {
+			log.Errorf("vhost %s quarantined after more than %d panics in %s", hostname, cfg.QuarantineThreshold, cfg.QuarantineWindow)
+		}
+		err = panicErr
+	}()
+	return v.serve(c, vhost)
+}
+
+// recordPanic records a panic of the vhost with the given key and quarantines it when it panicked more than
+// the threshold within the window. It returns true if the vhost got quarantined.
+func (v *Vhosts) recordPanic(key string, cfg Config) bool {
+	v.panicMutex.Lock()
+	defer v.panicMutex.Unlock()
+	if v.panics == nil {
+		v.panics = make(map[string][]time.Time)
+		v.quarantine = make(map[string]time.Time)
+	}
+
+	// drop the panics that fell out of the window
+	now := time.Now()
+	panics := v.panics[key][:0]
+	for _, at := range v.panics[key] {
+		if now.Sub(at) < cfg.QuarantineWindow {
+			panics = append(panics, at)
+		}
+	}
+	panics = append(panics, now)
+	v.panics[key] = panics
+
+	if len(panics) <= cfg.QuarantineThreshold {
+		return false
+	}
+	var until time.Time
+	if cfg.QuarantineDuration > 0 {
+		until = now.Add(cfg.QuarantineDuration)
+	}
+	v.quarantine[key] = until
+	delete(v.panics, key)
+	return true
+}
+
+// isQuarantined reports whether the vhost with the given key is quarantined
+func (v *Vhosts) isQuarantined(key string) bool {
+	v.panicMutex.Lock()
+	defer v.panicMutex.Unlock()
+	until, ok := v.quarantine[key]
+	if !ok {
+		return false
+	}
+	if !until.IsZero() && time.Now().After(until) {
+		delete(v.quarantine, key)
+		return false
+	}
+	return true
+}
+
+// Quarantined reports whether the vhost with the given hostname or alias is quarantined after panicking
+func (v *Vhosts) Quarantined(hostname string) bool {
+	vhost, ok := v.Get(hostname)
+	if !ok {
+		return false
+	}
+	return v.isQuarantined(vhostKey(vhost))
+}
+
+// Release lifts the quarantine of the vhost with the given hostname or alias and forgets its panics
+func (v *Vhosts) Release(hostname string) error {
+	vhost, ok := v.Get(hostname)
+	if !ok {
+		return errors.New("vhost not found")
+	}
+	v.panicMutex.Lock()
+	defer v.panicMutex.Unlock()
+	delete(v.quarantine, vhostKey(vhost))
+	delete(v.panics, vhostKey(vhost))
+	return nil
+}
+
+// forgetPanics drops the panics and the quarantine of the vhosts with the given keys, a vhost added again under
+// the same hostname starts with a clean record
+func (v *Vhosts) forgetPanics(keys ...string) {
+	v.panicMutex.Lock()
+	defer v.panicMutex.Unlock()
+	for _, key := range keys {
+		delete(v.quarantine, key)
+		delete(v.panics, key)
+	}
+}
+
+// retainPanics drops the panics and the quarantine of every vhost that isn't in the vhosts list anymore
+func (v *Vhosts) retainPanics(vhosts []Vhost) {
+	keys := make(map[string]bool, len(vhosts))
+	for _, vhost := range vhosts {
+		keys[vhostKey(vhost)] = true
+	}
+	v.panicMutex.Lock()
+	defer v.panicMutex.Unlock()
+	for key := range v.quarantine {
+		if !keys[key] {
+			delete(v.quarantine, key)
+		}
+	}
+	for key := range v.panics {
+		if !keys[key] {
+			delete(v.panics, key)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package vhosts
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXVhost_Recover(t *testing.T) {
+	var recovered *PanicError
+	vhosts := &Vhosts{}
+	vhosts.Add(NewVhost("panic.com", "", "13", func(c *fiber.Ctx) error {
+		panic("tenant bug")
+	}, func(c *fiber.Ctx, err error) error {
+		errors.As(err, &recovered)
+		return c.Status(500).SendString(err.Error())
+	}))
+	vhosts.Add(NewVhost("fine.com", "", "", mockMiddleware, mockErrorHandler))
+
+	app := fiber.New()
+	app.Use(XVhost(vhosts, Config{Recover: true}))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "http://panic.com/", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, 500, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "vhost panic.com ( website 13 ) panicked: tenant bug", string(body))
+	if assert.NotNil(t, recovered) {
+		assert.Equal(t, "panic.com", recovered.Hostname)
+		assert.Equal(t, "13", recovered.WebsiteID)
+		assert.Equal(t, "tenant bug", recovered.Value)
+		assert.NotEmpty(t, recovered.Stack)
+	}
+
+	// the other vhosts are unaffected
+	resp, err = app.Test(httptest.NewRequest("GET", "http://fine.com/", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestXVhost_Recover_InChain(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.Add(NewVhost("panic.com", "", "", mockMiddleware, nil))
+	vhosts.UseVhost("panic.com", func(c *fiber.Ctx) error {
+		panic(errors.New("middleware bug"))
+	})
+
+	app := fiber.New()
+	app.Use(XVhost(vhosts, Config{Recover: true}))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "http://panic.com/", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, 500, resp.StatusCode)
+}
+
+func TestXVhost_Quarantine(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.Add(NewVhost("panic.com", "", "", func(c *fiber.Ctx) error {
+		if c.Path() == "/panic" {
+			panic("tenant bug")
+		}
+		return c.SendString("ok")
+	}, nil))
+
+	app := fiber.New()
+	app.Use(XVhost(vhosts, Config{
+		Recover:             true,
+		QuarantineThreshold: 2,
+		QuarantineWindow:    time.Minute,
+	}))
+
+	// two panics are tolerated, the third one quarantines the vhost
+	for i := 0; i < 3; i++ {
+		assert.False(t, vhosts.Quarantined("panic.com"))
+		resp, err := app.Test(httptest.NewRequest("GET", "http://panic.com/panic", nil))
+		assert.Nil(t, err)
+		assert.Equal(t, 500, resp.StatusCode)
+	}
+	assert.True(t, vhosts.Quarantined("panic.com"))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "http://panic.com/", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, 503, resp.StatusCode)
+
+	// releasing the vhost serves it again
+	assert.Nil(t, vhosts.Release("panic.com"))
+	assert.NotNil(t, vhosts.Release("missing.com"))
+	resp, err = app.Test(httptest.NewRequest("GET", "http://panic.com/", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestVhosts_QuarantineExpires(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.Add(NewVhost("panic.com", "", "", mockMiddleware, nil))
+	vhost, _ := vhosts.Get("panic.com")
+	cfg := Config{QuarantineThreshold: 1, QuarantineWindow: time.Minute, QuarantineDuration: 10 * time.Millisecond}
+
+	assert.False(t, vhosts.recordPanic(vhostKey(vhost), cfg))
+	assert.True(t, vhosts.recordPanic(vhostKey(vhost), cfg))
+	assert.True(t, vhosts.Quarantined("panic.com"))
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, vhosts.Quarantined("panic.com"))
+}
+
+func TestVhosts_QuarantineForgotten(t *testing.T) {
+	cfg := Config{QuarantineThreshold: 1, QuarantineWindow: time.Minute}
+	quarantine := func(vhosts *Vhosts, hostname string) {
+		vhost, ok := vhosts.Get(hostname)
+		assert.True(t, ok)
+		vhosts.recordPanic(vhostKey(vhost), cfg)
+		vhosts.recordPanic(vhostKey(vhost), cfg)
+		assert.True(t, vhosts.Quarantined(hostname))
+	}
+
+	// a vhost added again under the same hostname starts with a clean record
+	vhosts := &Vhosts{}
+	assert.Nil(t, vhosts.Add(NewVhost("panic.com", "", "", mockMiddleware, nil)))
+	quarantine(vhosts, "panic.com")
+	assert.Nil(t, vhosts.Remove("panic.com"))
+	assert.Nil(t, vhosts.Add(NewVhost("panic.com", "", "", mockMiddleware, nil)))
+	assert.False(t, vhosts.Quarantined("panic.com"))
+
+	quarantine(vhosts, "panic.com")
+	assert.Nil(t, vhosts.Update(func(tx *Tx) error {
+		return tx.Remove("panic.com")
+	}))
+	assert.Nil(t, vhosts.Add(NewVhost("panic.com", "", "", mockMiddleware, nil)))
+	assert.False(t, vhosts.Quarantined("panic.com"))
+
+	// loading keeps the quarantine of the vhosts that are still there
+	file := filepath.Join(t.TempDir(), "vhosts.bin")
+	persisted := newPersistedVhosts(t)
+	assert.Nil(t, persisted.Save(file))
+	assert.Nil(t, vhosts.Add(NewVhost("example.com", "", "", mockMiddleware, nil)))
+	quarantine(vhosts, "panic.com")
+	quarantine(vhosts, "example.com")
+	assert.Nil(t, vhosts.Load(file))
+	assert.Nil(t, vhosts.Add(NewVhost("panic.com", "", "", mockMiddleware, nil)))
+	assert.False(t, vhosts.Quarantined("panic.com"))
+	assert.True(t, vhosts.Quarantined("example.com"))
+}
@@ -0,0 +1,119 @@
+package vhosts
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PathRoute routes the requests of a vhost under a path prefix to a handler tag
+type PathRoute struct {
+	Prefix      string // prefix is the path prefix ( /blog )
+	HandlerTag  string // handlerTag is the tag of the handler ( and error handler ) serving the prefix
+	StripPrefix bool   // stripPrefix removes the prefix from the path before the handler is called
+}
+
+// AddRoute routes the requests of the vhost with the given hostname or alias under the path prefix to the handler tag.
+// The longest matching prefix wins, requests without a matching prefix are served by the handler of the vhost.
+func (v *Vhosts) AddRoute(hostname, prefix, handlerTag string, stripPrefix bool) error {
+	prefix = normalizePrefix(prefix)
+
+	v.mutex.Lock()
+	defer v.unlock()
+	i, ok := v.index[indexKey(hostname)]
+	if !ok {
+		return errors.New("vhost not found")
+	}
+	if _, ok := v.handlers[handlerTag]; !ok {
+		return errors.New("handler not found")
+	}
+	for _, route := range v.Vhosts[i].Routes {
+		if route.Prefix == prefix {
+			return errors.New("route already exists")
+		}
+	}
+
+	// copy the routes so vhosts handed out by Get keep their own slice
+	old, vhost := v.Vhosts[i], v.Vhosts[i]
+	routes := make([]PathRoute, 0, len(vhost.Routes)+1)
+	routes = append(routes, vhost.Routes...)
+	vhost.Routes = append(routes, PathRoute{Prefix: prefix, HandlerTag: handlerTag, StripPrefix: stripPrefix})
+	v.updateVhost(i, vhost)
+	v.touch()
+	v.emit(Event{Type: EventHandlerChanged, Hostname: vhost.Hostname, Old: &old, New: &vhost, Version: v.Version})
+	return nil
+}
+
+// RemoveRoute removes the route for the path prefix from the vhost with the given hostname or alias
+func (v *Vhosts) RemoveRoute(hostname, prefix string) error {
+	prefix = normalizePrefix(prefix)
+
+	v.mutex.Lock()
+	defer v.unlock()
+	i, ok := v.index[indexKey(hostname)]
+	if !ok {
+		return errors.New("vhost not found")
+	}
+	for j, route := range v.Vhosts[i].Routes {
+		if route.Prefix == prefix {
+			old, vhost := v.Vhosts[i], v.Vhosts[i]
+			routes := make([]PathRoute, 0, len(vhost.Routes)-1)
+			routes = append(routes, vhost.Routes[:j]...)
+			vhost.Routes = append(routes, vhost.Routes[j+1:]...)
+			v.updateVhost(i, vhost)
+			v.touch()
+			v.emit(Event{Type: EventHandlerChanged, Hostname: vhost.Hostname, Old: &old, New: &vhost, Version: v.Version})
+			return nil
+		}
+	}
+	return errors.New("route not found")
+}
+
+// route returns the vhost to serve the request with, the vhost itself or a copy serving the handler tag of the
+// longest route matching the path. The prefix of the route is stripped from the path if the route asks for it.
+func (v *Vhosts) route(c *fiber.Ctx, vhost Vhost) (Vhost, *PathRoute, error) {
+	route, ok := matchRoute(vhost.Routes, c.Path())
+	if !ok {
+		return vhost, nil, nil
+	}
+
+	handler, ok := v.GetHandler(route.HandlerTag)
+	if !ok {
+		return vhost, route, fiber.ErrNotFound
+	}
+	vhost.Handler = handler
+	vhost.Path = route.HandlerTag
+	if errorHandler, ok := v.GetErrorHandler(route.HandlerTag); ok {
+		vhost.ErrorHandler = errorHandler
+	}
+
+	if route.StripPrefix && route.Prefix != "/" {
+		path := strings.TrimPrefix(c.Path(), route.Prefix)
+		if path == "" {
+			path = "/"
+		}
+		c.Path(path)
+	}
+	return vhost, route, nil
+}
+
+// matchRoute returns the route with the longest prefix matching the path
+func matchRoute(routes []PathRoute, path string) (*PathRoute, bool) {
+	var best *PathRoute
+	for i := range routes {
+		route := &routes[i]
+		if route.Prefix != "/" && path != route.Prefix && !strings.HasPrefix(path, route.Prefix+"/") {
+			continue
+		}
+		if best == nil || len(route.Prefix) > len(best.Prefix) {
+			best = route
+		}
+	}
+	return best, best != nil
+}
+
+// normalizePrefix returns the prefix with a leading and without a trailing slash ( / stays / )
+func normalizePrefix(prefix string) string {
+	return "/" + strings.Trim(prefix, "/")
+}
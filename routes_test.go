@@ -0,0 +1,127 @@
+package vhosts
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVhosts_AddRoute(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	vhosts.Add(NewVhost("example.com", "", "", mockMiddleware, mockErrorHandler))
+	vhosts.AddHandler("blog", mockMiddleware)
+
+	assert.Nil(t, vhosts.AddRoute("example.com", "/blog/", "blog", false))
+	assert.NotNil(t, vhosts.AddRoute("example.com", "blog", "blog", false), "duplicate prefix")
+	assert.NotNil(t, vhosts.AddRoute("example.com", "/api", "missing", false), "missing handler tag")
+	assert.NotNil(t, vhosts.AddRoute("missing.com", "/api", "blog", false), "missing vhost")
+
+	vhost, _ := vhosts.Get("example.com")
+	assert.Equal(t, []PathRoute{{Prefix: "/blog", HandlerTag: "blog"}}, vhost.Routes)
+
+	assert.Nil(t, vhosts.RemoveRoute("example.com", "/blog"))
+	assert.NotNil(t, vhosts.RemoveRoute("example.com", "/blog"))
+	vhost, _ = vhosts.Get("example.com")
+	assert.Empty(t, vhost.Routes)
+}
+
+func TestXVhost_PathRoutes(t *testing.T) {
+	echo := func(name string) FiberHandler {
+		return func(c *fiber.Ctx) error {
+			if c.Path() == "/fail" {
+				return fiber.ErrTeapot
+			}
+			return c.SendString(name + " " + c.Path())
+		}
+	}
+
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	vhosts.Add(NewVhost("example.com", "", "", echo("site"), nil))
+	vhosts.AddHandler("root", echo("root"))
+	vhosts.AddHandler("blog", echo("blog"))
+	vhosts.AddHandler("api", echo("api"))
+	vhosts.AddHandler("apiv2", echo("apiv2"))
+	vhosts.AddErrorHandler("api", func(c *fiber.Ctx, err error) error {
+		return c.Status(400).SendString("api error")
+	})
+	assert.Nil(t, vhosts.AddRoute("example.com", "/blog", "blog", false))
+	assert.Nil(t, vhosts.AddRoute("example.com", "/api", "api", true))
+	assert.Nil(t, vhosts.AddRoute("example.com", "/api/v2", "apiv2", true))
+
+	app := fiber.New()
+	app.Use(XVhost(vhosts))
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/", 200, "site /"},
+		{"/blogger", 200, "site /blogger"},
+		{"/blog", 200, "blog /blog"},
+		{"/blog/post/1", 200, "blog /blog/post/1"},
+		{"/api", 200, "api /"},
+		{"/api/users", 200, "api /users"},
+		{"/api/v2/users", 200, "apiv2 /users"},
+		{"/api/v20", 200, "api /v20"},
+		{"/api/fail", 400, "api error"},
+		{"/fail", 418, "I'm a teapot"},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", "http://example.com"+tt.path, nil))
+		assert.Nil(t, err, tt.path)
+		assert.Equal(t, tt.status, resp.StatusCode, tt.path)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, tt.body, string(body), tt.path)
+	}
+
+	// a root route catches everything without a longer prefix
+	assert.Nil(t, vhosts.AddRoute("example.com", "/", "root", false))
+	resp, err := app.Test(httptest.NewRequest("GET", "http://example.com/about", nil))
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "root /about", string(body))
+
+	// a handler tag removed after the route was added is a 404
+	vhosts.RemoveHandler("blog")
+	resp, err = app.Test(httptest.NewRequest("GET", "http://example.com/blog", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+}
+
+func TestVhostMiddleware(t *testing.T) {
+	Vhs = &Vhosts{}
+	Vhs.InitializeHandlerSpace()
+	Vhs.Add(NewVhost("example.com", "", "", mockMiddleware, nil))
+	Vhs.AddHandler("blog", func(c *fiber.Ctx) error {
+		return c.SendString("blog")
+	})
+	Vhs.AddRoute("example.com", "/blog", "blog", false)
+
+	app := fiber.New()
+	app.Use(VhostMiddleware)
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("app")
+	})
+
+	tests := map[string]string{
+		"http://example.com/":        "Hello, World!",
+		"http://example.com/blog/hi": "blog",
+		"http://unknown.com/":        "app",
+	}
+	for url, want := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", url, nil))
+		assert.Nil(t, err, url)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, want, string(body), url)
+	}
+
+	// routing doesn't touch the stored vhost
+	vhost, _ := Vhs.Get("example.com")
+	assert.Equal(t, "", vhost.Path)
+}
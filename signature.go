@@ -0,0 +1,204 @@
+package vhosts
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// signaturePrefix starts the signature line in front of the payload of a signed vhosts file
+const signaturePrefix = "vhosts-signature:v1:"
+
+var (
+	// ErrUnsigned is returned by Load when trusted keys are configured and the file isn't signed
+	ErrUnsigned = errors.New("vhosts file isn't signed")
+	// ErrUntrustedKey is returned by Load when the file is signed with a key that isn't trusted
+	ErrUntrustedKey = errors.New("vhosts file is signed with an untrusted key")
+	// ErrInvalidSignature is returned by Load when the signature of the file doesn't verify
+	ErrInvalidSignature = errors.New("vhosts file signature doesn't verify")
+)
+
+// Signer signs the vhosts files written by Save, see WithSigner
+type Signer interface {
+	// KeyID identifies the key, Load looks up the trusted key with the same id
+	KeyID() string
+	// Algorithm is the name of the signature algorithm ( hmac-sha256 or ed25519 )
+	Algorithm() string
+	// Sign returns the signature of data
+	Sign(data []byte) ([]byte, error)
+}
+
+// Verifier verifies the signature of the vhosts files read by Load, see WithTrustedKeys
+type Verifier interface {
+	// KeyID identifies the key
+	KeyID() string
+	// Algorithm is the name of the signature algorithm ( hmac-sha256 or ed25519 )
+	Algorithm() string
+	// Verify reports whether sig is a valid signature of data
+	Verify(data, sig []byte) bool
+}
+
+// HMACKey is a shared secret signing and verifying vhosts files with HMAC-SHA256
+type HMACKey struct {
+	id  string
+	key []byte
+}
+
+// NewHMACKey returns the HMAC-SHA256 key with the given id
+func NewHMACKey(keyID string, key []byte) *HMACKey {
+	return &HMACKey{id: keyID, key: key}
+}
+
+// KeyID returns the id of the key
+func (k *HMACKey) KeyID() string { return k.id }
+
+// Algorithm returns hmac-sha256
+func (k *HMACKey) Algorithm() string { return "hmac-sha256" }
+
+// Sign returns the HMAC-SHA256 of data
+func (k *HMACKey) Sign(data []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, k.key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// Verify reports whether sig is the HMAC-SHA256 of data
+func (k *HMACKey) Verify(data, sig []byte) bool {
+	expected, _ := k.Sign(data)
+	return hmac.Equal(expected, sig)
+}
+
+// Ed25519Signer signs vhosts files with an Ed25519 private key, the edge nodes only need the public key
+type Ed25519Signer struct {
+	id  string
+	key ed25519.PrivateKey
+}
+
+// NewEd25519Signer returns the signer for the Ed25519 private key with the given id
+func NewEd25519Signer(keyID string, key ed25519.PrivateKey) *Ed25519Signer {
+	return &Ed25519Signer{id: keyID, key: key}
+}
+
+// KeyID returns the id of the key
+func (s *Ed25519Signer) KeyID() string { return s.id }
+
+// Algorithm returns ed25519
+func (s *Ed25519Signer) Algorithm() string { return "ed25519" }
+
+// Sign returns the Ed25519 signature of data
+func (s *Ed25519Signer) Sign(data []byte) ([]byte, error) {
+	if len(s.key) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid ed25519 private key")
+	}
+	return ed25519.Sign(s.key, data), nil
+}
+
+// Ed25519Verifier verifies vhosts files signed with an Ed25519 private key
+type Ed25519Verifier struct {
+	id  string
+	key ed25519.PublicKey
+}
+
+// NewEd25519Verifier returns the verifier for the Ed25519 public key with the given id
+func NewEd25519Verifier(keyID string, key ed25519.PublicKey) *Ed25519Verifier {
+	return &Ed25519Verifier{id: keyID, key: key}
+}
+
+// KeyID returns the id of the key
+func (v *Ed25519Verifier) KeyID() string { return v.id }
+
+// Algorithm returns ed25519
+func (v *Ed25519Verifier) Algorithm() string { return "ed25519" }
+
+// Verify reports whether sig is the Ed25519 signature of data
+func (v *Ed25519Verifier) Verify(data, sig []byte) bool {
+	return len(v.key) == ed25519.PublicKeySize && ed25519.Verify(v.key, data, sig)
+}
+
+// WithSigner signs the files written by Save with the given key
+func WithSigner(signer Signer) FileOption {
+	return func(o *fileOptions) {
+		o.signer = signer
+	}
+}
+
+// WithTrustedKeys makes Load refuse files that aren't signed by one of the given keys. Several keys can be
+// trusted at once to rotate keys, the key is picked by the key id written next to the signature.
+func WithTrustedKeys(keys ...Verifier) FileOption {
+	return func(o *fileOptions) {
+		o.trusted = append(o.trusted, keys...)
+	}
+}
+
+// sign returns the payload behind a signature line if a signer is configured
+func (o fileOptions) sign(payload []byte) ([]byte, error) {
+	if o.signer == nil {
+		return payload, nil
+	}
+	alg, keyID := o.signer.Algorithm(), o.signer.KeyID()
+	if strings.ContainsAny(alg+keyID, ":\n") || keyID == "" {
+		return nil, errors.New("invalid signing key id")
+	}
+	header := signaturePrefix + alg + ":" + keyID
+	sig, err := o.signer.Sign(signedData(header, payload))
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(header + ":" + base64.StdEncoding.EncodeToString(sig) + "\n")
+	out.Write(payload)
+	return out.Bytes(), nil
+}
+
+// verify strips the signature line from the data and returns the payload. The signature must verify against
+// one of the trusted keys if any are configured, otherwise it is ignored.
+func (o fileOptions) verify(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(signaturePrefix)) {
+		if len(o.trusted) > 0 {
+			return nil, ErrUnsigned
+		}
+		return data, nil
+	}
+
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		return nil, ErrInvalidSignature
+	}
+	line, payload := string(data[:end]), data[end+1:]
+	fields := strings.Split(strings.TrimPrefix(line, signaturePrefix), ":")
+	if len(fields) != 3 {
+		return nil, ErrInvalidSignature
+	}
+	alg, keyID := fields[0], fields[1]
+	if len(o.trusted) == 0 {
+		return payload, nil
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	for _, key := range o.trusted {
+		if key.KeyID() != keyID || key.Algorithm() != alg {
+			continue
+		}
+		if !key.Verify(signedData(signaturePrefix+alg+":"+keyID, payload), sig) {
+			return nil, ErrInvalidSignature
+		}
+		return payload, nil
+	}
+	return nil, ErrUntrustedKey
+}
+
+// signedData returns the data covered by the signature, the header binds the algorithm and the key id
+func signedData(header string, payload []byte) []byte {
+	data := make([]byte, 0, len(header)+1+len(payload))
+	data = append(data, header...)
+	data = append(data, '\n')
+	return append(data, payload...)
+}
@@ -0,0 +1,130 @@
+package vhosts
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignature_HMAC(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vhosts.yaml")
+	key := NewHMACKey("build-1", []byte("secret"))
+	vhosts := newPersistedVhosts(t)
+	assert.Nil(t, vhosts.Save(file, WithSigner(key)))
+
+	data, err := os.ReadFile(file)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "vhosts-signature:v1:hmac-sha256:build-1:"))
+
+	loaded := &Vhosts{}
+	assert.Nil(t, loaded.Load(file, WithTrustedKeys(key)))
+	assert.Equal(t, vhosts.Vhosts, loaded.Vhosts)
+
+	// a different secret with the same key id doesn't verify
+	assert.ErrorIs(t, loaded.Load(file, WithTrustedKeys(NewHMACKey("build-1", []byte("other")))), ErrInvalidSignature)
+	// without trusted keys the signature is ignored
+	assert.Nil(t, loaded.Load(file))
+}
+
+func TestSignature_Ed25519(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "vhosts.bin")
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	signer, verifier := NewEd25519Signer("build-1", private), NewEd25519Verifier("build-1", public)
+
+	vhosts := newPersistedVhosts(t)
+	assert.Nil(t, vhosts.Save(file, WithSigner(signer)))
+	loaded := &Vhosts{}
+	assert.Nil(t, loaded.Load(file, WithTrustedKeys(verifier)))
+	assert.Len(t, loaded.Vhosts, 3)
+
+	// flipping a single bit of the payload breaks the signature
+	data, err := os.ReadFile(file)
+	assert.Nil(t, err)
+	data[len(data)-1] ^= 1
+	assert.Nil(t, os.WriteFile(file, data, 0644))
+	assert.ErrorIs(t, loaded.Load(file, WithTrustedKeys(verifier)), ErrInvalidSignature)
+
+	// swapping the key id for another trusted key doesn't help
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	assert.Nil(t, vhosts.Save(file, WithSigner(signer)))
+	data, err = os.ReadFile(file)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(file, []byte(strings.Replace(string(data), ":build-1:", ":build-2:", 1)), 0644))
+	assert.ErrorIs(t, loaded.Load(file, WithTrustedKeys(verifier, NewEd25519Verifier("build-2", other))), ErrInvalidSignature)
+}
+
+func TestSignature_Refused(t *testing.T) {
+	dir := t.TempDir()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	trusted := WithTrustedKeys(NewEd25519Verifier("build-1", public))
+	vhosts := newPersistedVhosts(t)
+
+	// unsigned files are refused once keys are configured
+	unsigned := filepath.Join(dir, "unsigned.bin")
+	assert.Nil(t, vhosts.Save(unsigned))
+	assert.ErrorIs(t, (&Vhosts{}).Load(unsigned, trusted), ErrUnsigned)
+
+	// files signed by an unknown key are refused
+	untrusted := filepath.Join(dir, "untrusted.bin")
+	assert.Nil(t, vhosts.Save(untrusted, WithSigner(NewHMACKey("rogue", []byte("secret")))))
+	assert.ErrorIs(t, (&Vhosts{}).Load(untrusted, trusted), ErrUntrustedKey)
+
+	// a malformed signature line is refused
+	malformed := filepath.Join(dir, "malformed.bin")
+	assert.Nil(t, os.WriteFile(malformed, []byte("vhosts-signature:v1:ed25519\n"), 0644))
+	assert.True(t, errors.Is((&Vhosts{}).Load(malformed, trusted), ErrInvalidSignature))
+}
+
+func TestSignature_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	oldPublic, oldPrivate, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	newPublic, newPrivate, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	vhosts := newPersistedVhosts(t)
+
+	oldFile, newFile := filepath.Join(dir, "old.bin"), filepath.Join(dir, "new.bin")
+	assert.Nil(t, vhosts.Save(oldFile, WithSigner(NewEd25519Signer("2025", oldPrivate))))
+	assert.Nil(t, vhosts.Save(newFile, WithSigner(NewEd25519Signer("2026", newPrivate))))
+
+	// during the rotation both keys are trusted
+	trusted := WithTrustedKeys(NewEd25519Verifier("2025", oldPublic), NewEd25519Verifier("2026", newPublic))
+	assert.Nil(t, (&Vhosts{}).Load(oldFile, trusted))
+	assert.Nil(t, (&Vhosts{}).Load(newFile, trusted))
+
+	// once the old key is retired its files are refused
+	assert.ErrorIs(t, (&Vhosts{}).Load(oldFile, WithTrustedKeys(NewEd25519Verifier("2026", newPublic))), ErrUntrustedKey)
+}
+
+func TestSignature_Backups(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vhosts.bin")
+	key := NewHMACKey("build-1", []byte("secret"))
+	vhosts := newPersistedVhosts(t)
+	assert.Nil(t, vhosts.Save(file, WithSigner(key), WithBackups(1)))
+	assert.Nil(t, vhosts.Remove("example.com"))
+	assert.Nil(t, vhosts.Save(file, WithSigner(NewHMACKey("rogue", []byte("secret"))), WithBackups(1)))
+
+	// the backup is signed by a trusted key, the primary isn't
+	loaded := &Vhosts{}
+	assert.Nil(t, loaded.Load(file, WithTrustedKeys(key), WithBackups(1)))
+	assert.Len(t, loaded.Vhosts, 3)
+}
+
+func TestSignature_InvalidKeyID(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vhosts.bin")
+	vhosts := newPersistedVhosts(t)
+	assert.Error(t, vhosts.Save(file, WithSigner(NewHMACKey("a:b", []byte("secret")))))
+	assert.Error(t, vhosts.Save(file, WithSigner(NewHMACKey("", []byte("secret")))))
+	assert.Error(t, vhosts.Save(file, WithSigner(NewEd25519Signer("build-1", nil))))
+	assert.False(t, doesFileExist(file))
+}
@@ -0,0 +1,223 @@
+package vhosts
+
+import (
+	"hash/maphash"
+	"maps"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// cowShards is the number of shards of a cowMap, a write only copies the shard of its key
+const cowShards = 64
+
+// cowSeed is the seed of the hash used to pick the shard of a key
+var cowSeed = maphash.MakeSeed()
+
+// cowMap is a copy-on-write map of hostnames to vhosts split in shards. The shards of a published
+// routing table are never modified, a write copies the shard of its key once and owns it from then on.
+type cowMap struct {
+	shards [cowShards]map[string]*Vhost
+	owned  uint64 // owned has a bit set for every shard copied since the routing table was cloned
+}
+
+// shardOf returns the shard of the given key
+func shardOf(key string) int {
+	return int(maphash.String(cowSeed, key) % cowShards)
+}
+
+// get returns the vhost stored under the given key
+func (m *cowMap) get(key string) (*Vhost, bool) {
+	vhost, ok := m.shards[shardOf(key)][key]
+	return vhost, ok
+}
+
+// set stores the vhost under the given key, copying its shard first if it is still shared
+func (m *cowMap) set(key string, vhost *Vhost) {
+	m.own(shardOf(key))[key] = vhost
+}
+
+// delete removes the key, copying its shard first if it is still shared
+func (m *cowMap) delete(key string) {
+	if _, ok := m.get(key); ok {
+		delete(m.own(shardOf(key)), key)
+	}
+}
+
+// own returns shard i, copying it first if it is still shared with a published routing table
+func (m *cowMap) own(i int) map[string]*Vhost {
+	if m.owned&(1<<i) == 0 {
+		shard := make(map[string]*Vhost, len(m.shards[i])+1)
+		maps.Copy(shard, m.shards[i])
+		m.shards[i] = shard
+		m.owned |= 1 << i
+	}
+	return m.shards[i]
+}
+
+// reset empties the map
+func (m *cowMap) reset() {
+	*m = cowMap{}
+}
+
+// routingTable is an immutable snapshot of everything the request path reads. It is published through an
+// atomic pointer so requests never take a lock, mutations copy the current table, change the copy and swap it in.
+type routingTable struct {
+	// index maps the normalized hostname ( and port suffix ) to the vhost
+	index cowMap
+	// wildcards maps the suffix of single label wildcards ( *.example.com ) to the vhost
+	wildcards cowMap
+	// deepWildcards maps the suffix of multi label wildcards ( **.example.com ) to the vhost
+	deepWildcards cowMap
+	// patterns is the list of host templates and regexes in the order the vhosts were added
+	patterns []hostPattern
+	// handlers is a copy of the handlers list
+	handlers map[string]FiberHandler
+	// errorHandlers is a copy of the error handlers list
+	errorHandlers map[string]FiberErrorHandler
+	// defaultErrorHandler handles the errors of vhosts without an error handler
+	defaultErrorHandler FiberErrorHandler
+	// apps is a copy of the apps mounted on the vhosts
+	apps map[string]fasthttp.RequestHandler
+	// chains are the compiled middleware chains by vhost key and handler tag ( see publishChains )
+	chains map[string]*middlewareChain
+}
+
+// emptyRoutingTable is used until the first routing table is published
+var emptyRoutingTable = &routingTable{}
+
+// routing returns the current routing table, it is safe to call without holding the lock and must not be called
+// while holding it. A vhosts list set without Add ( &Vhosts{Vhosts: ...} ) is indexed by the first call.
+func (v *Vhosts) routing() *routingTable {
+	if table := v.table.Load(); table != nil {
+		return table
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if v.table.Load() == nil {
+		v.reindex()
+	}
+	return v.table.Load()
+}
+
+// published returns the routing table published last, the caller must hold the write lock
+func (v *Vhosts) published() *routingTable {
+	if table := v.table.Load(); table != nil {
+		return table
+	}
+	return emptyRoutingTable
+}
+
+// publish applies update to a copy of the current routing table and swaps it in, the caller must hold the write lock
+func (v *Vhosts) publish(update func(table *routingTable)) {
+	table := *v.published()
+	table.index.owned, table.wildcards.owned, table.deepWildcards.owned = 0, 0, 0
+	update(&table)
+	v.table.Store(&table)
+}
+
+// addVhost adds the vhost to the table under its hostname and aliases
+func (t *routingTable) addVhost(vhost *Vhost) {
+	for _, entry := range vhostEntries(*vhost) {
+		host := entry.host
+		if isHostPattern(host) {
+			// patterns are validated by Add, a pattern that no longer compiles is simply not matched
+			re, err := compileHostPattern(host)
+			if err != nil {
+				continue
+			}
+			for _, suffix := range entry.suffixes {
+				t.index.set(host+suffix, vhost)
+				// the full slice expression makes append copy, older tables keep their patterns
+				t.patterns = append(t.patterns[:len(t.patterns):len(t.patterns)], hostPattern{re: re, name: host, vhost: vhost, port: suffix})
+			}
+			continue
+		}
+		for _, suffix := range entry.suffixes {
+			t.index.set(host+suffix, vhost)
+			switch {
+			case strings.HasPrefix(host, "**."):
+				t.deepWildcards.set(host[3:]+suffix, vhost)
+			case strings.HasPrefix(host, "*."):
+				t.wildcards.set(host[2:]+suffix, vhost)
+			}
+		}
+	}
+}
+
+// replaceVhost points the hostname and aliases of the vhost to the new version of it, the names must not have changed
+func (t *routingTable) replaceVhost(vhost *Vhost) {
+	entries := vhostEntries(*vhost)
+	if len(entries) == 0 {
+		return
+	}
+	old, ok := t.index.get(entries[0].host + entries[0].suffixes[0])
+	if !ok {
+		t.addVhost(vhost)
+		return
+	}
+	for _, entry := range entries {
+		host := entry.host
+		for _, suffix := range entry.suffixes {
+			t.index.set(host+suffix, vhost)
+			switch {
+			case isHostPattern(host):
+			case strings.HasPrefix(host, "**."):
+				t.deepWildcards.set(host[3:]+suffix, vhost)
+			case strings.HasPrefix(host, "*."):
+				t.wildcards.set(host[2:]+suffix, vhost)
+			}
+		}
+	}
+	patterns := make([]hostPattern, len(t.patterns))
+	for i, pattern := range t.patterns {
+		if pattern.vhost == old {
+			pattern.vhost = vhost
+		}
+		patterns[i] = pattern
+	}
+	t.patterns = patterns
+}
+
+// removeVhost removes the hostname and aliases of the vhost from the table
+func (t *routingTable) removeVhost(vhost Vhost) {
+	entries := vhostEntries(vhost)
+	if len(entries) == 0 {
+		return
+	}
+	old, ok := t.index.get(entries[0].host + entries[0].suffixes[0])
+	if !ok {
+		return
+	}
+	for _, entry := range entries {
+		host := entry.host
+		for _, suffix := range entry.suffixes {
+			t.index.delete(host + suffix)
+			switch {
+			case isHostPattern(host):
+			case strings.HasPrefix(host, "**."):
+				t.deepWildcards.delete(host[3:] + suffix)
+			case strings.HasPrefix(host, "*."):
+				t.wildcards.delete(host[2:] + suffix)
+			}
+		}
+	}
+	patterns := make([]hostPattern, 0, len(t.patterns))
+	for _, pattern := range t.patterns {
+		if pattern.vhost != old {
+			patterns = append(patterns, pattern)
+		}
+	}
+	t.patterns = patterns
+}
+
+// resetVhosts rebuilds the hostname index of the table from the given vhosts list
+func (t *routingTable) resetVhosts(vhosts []Vhost) {
+	t.index.reset()
+	t.wildcards.reset()
+	t.deepWildcards.reset()
+	t.patterns = nil
+	for _, vhost := range vhosts {
+		t.addVhost(&vhost)
+	}
+}
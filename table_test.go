@@ -0,0 +1,170 @@
+package vhosts
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutingTable_Snapshot(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	assert.Nil(t, vhosts.Add(NewVhost("a.example.com", "", "a", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.Add(NewVhost("*.example.com", "", "wildcard", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.Add(NewVhost("{tenant}.example.org", "", "template", mockMiddleware, mockErrorHandler)))
+
+	// a table held by a request is not affected by later mutations
+	before := vhosts.routing()
+	assert.Nil(t, vhosts.Add(NewVhost("b.example.com", "", "b", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.Remove("a.example.com"))
+	assert.Nil(t, vhosts.AddHandler("blog", mockMiddleware))
+
+	vhost, _, ok := before.match("a.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "a", vhost.WebsiteID)
+	vhost, _, ok = before.match("b.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "wildcard", vhost.WebsiteID)
+	_, ok = before.handlers["blog"]
+	assert.False(t, ok)
+
+	after := vhosts.routing()
+	assert.NotSame(t, before, after)
+	vhost, _, ok = after.match("a.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "wildcard", vhost.WebsiteID)
+	vhost, _, ok = after.match("b.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "b", vhost.WebsiteID)
+	vhost, _, ok = after.match("acme.example.org")
+	assert.True(t, ok)
+	assert.Equal(t, "template", vhost.WebsiteID)
+	_, ok = after.handlers["blog"]
+	assert.True(t, ok)
+}
+
+func TestRoutingTable_ReplaceVhost(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	assert.Nil(t, vhosts.AddHandler("blog", mockMiddleware))
+	vhost := NewVhost("{tenant}.example.com", "", "template", mockMiddleware, mockErrorHandler)
+	vhost.Aliases = []string{"example.net"}
+	assert.Nil(t, vhosts.Add(vhost))
+
+	before := vhosts.routing()
+	assert.Nil(t, vhosts.AddRoute("example.net", "/blog", "blog", false))
+
+	// the alias and the template resolve to the new version of the vhost
+	for _, hostname := range []string{"example.net", "acme.example.com"} {
+		vhost, _, ok := vhosts.Match(hostname)
+		assert.True(t, ok)
+		assert.Len(t, vhost.Routes, 1)
+		old, _, ok := before.match(hostname)
+		assert.True(t, ok)
+		assert.Empty(t, old.Routes)
+	}
+}
+
+func TestRoutingTable_ZeroValue(t *testing.T) {
+	vhosts := &Vhosts{}
+	_, ok := vhosts.Get("example.com")
+	assert.False(t, ok)
+	_, ok = vhosts.GetHandler("blog")
+	assert.False(t, ok)
+	_, ok = vhosts.DefaultErrorHandler()
+	assert.False(t, ok)
+}
+
+func TestRoutingTable_ConcurrentReadWrite(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	assert.Nil(t, vhosts.Add(NewVhost("static.example.com", "", "static", mockMiddleware, mockErrorHandler)))
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				// the vhost that is never touched must always be found
+				if vhost, ok := vhosts.Get("static.example.com"); !ok || vhost.WebsiteID != "static" {
+					t.Error("static vhost not found during writes")
+					return
+				}
+				vhosts.Get("host1.example.com")
+			}
+		}()
+	}
+	for i := 0; i < 1000; i++ {
+		hostname := fmt.Sprintf("host%d.example.com", i%10)
+		if _, ok := vhosts.Get(hostname); ok {
+			assert.Nil(t, vhosts.Remove(hostname))
+		} else {
+			assert.Nil(t, vhosts.Add(NewVhost(hostname, "", "", mockMiddleware, mockErrorHandler)))
+		}
+	}
+	stop.Store(true)
+	wg.Wait()
+}
+
+// BenchmarkVhosts_GetDuringWrites reads from parallel goroutines while a writer keeps adding and removing vhosts,
+// compare with the writes=false run: the readers never wait for the writer
+func BenchmarkVhosts_GetDuringWrites(b *testing.B) {
+	for _, writes := range []bool{false, true} {
+		vhosts := &Vhosts{}
+		for i := 0; i < 10000; i++ {
+			vhosts.Add(NewVhost(fmt.Sprintf("host%d.example.com", i), "", "", mockMiddleware, mockErrorHandler))
+		}
+
+		b.Run(fmt.Sprintf("writes=%t", writes), func(b *testing.B) {
+			var stop atomic.Bool
+			var wg sync.WaitGroup
+			if writes {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; !stop.Load(); i++ {
+						hostname := fmt.Sprintf("writer%d.example.com", i%100)
+						if vhosts.Add(NewVhost(hostname, "", "", mockMiddleware, mockErrorHandler)) != nil {
+							vhosts.Remove(hostname)
+						}
+					}
+				}()
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, ok := vhosts.Get("host9999.example.com"); !ok {
+						b.Error("vhost not found")
+						return
+					}
+				}
+			})
+			b.StopTimer()
+			stop.Store(true)
+			wg.Wait()
+		})
+	}
+}
+
+func TestRoutingTable_InitializeHandlerSpace(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	assert.Nil(t, vhosts.AddHandler("blog", mockMiddleware))
+	assert.Nil(t, vhosts.AddErrorHandler("blog", mockErrorHandler))
+	assert.Nil(t, vhosts.Add(NewVhost("example.com", "", "", mockMiddleware, mockErrorHandler)))
+
+	// the reset handlers are published to the request path
+	vhosts.InitializeHandlerSpace()
+	_, ok := vhosts.GetHandler("blog")
+	assert.False(t, ok)
+	_, ok = vhosts.GetErrorHandler("blog")
+	assert.False(t, ok)
+	assert.EqualError(t, vhosts.AddRoute("example.com", "/blog", "blog", false), "handler not found")
+}
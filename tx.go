@@ -0,0 +1,208 @@
+package vhosts
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Tx stages changes to the vhosts list, see Update. The changes only become visible to requests
+// when the transaction is committed, all at once.
+type Tx struct {
+	v       *Vhosts
+	vhosts  []Vhost        // vhosts is the staged vhosts list
+	index   map[string]int // index maps the hostnames of the staged vhosts list to their position
+	removed []string       // removed holds the keys of the removed vhosts
+	changed bool           // changed reports whether the transaction staged any change
+	events  []Event        // events holds the events of the staged changes, emitted on commit
+}
+
+// Update runs fn with a transaction staging changes to the vhosts list. If fn returns nil the changes are
+// validated and committed atomically with a single version bump, if it returns an error ( or panics ) nothing
+// is changed. The registry is locked while fn runs, fn must only use the transaction and not call other
+// methods of the vhosts list.
+func (v *Vhosts) Update(fn func(tx *Tx) error) error {
+	return v.update(anyVersion, fn)
+}
+
+// UpdateIfVersion works like Update but only if the version of the vhosts list is still the given version,
+// otherwise it returns a *VersionConflictError without calling fn
+func (v *Vhosts) UpdateIfVersion(version int64, fn func(tx *Tx) error) error {
+	return v.update(version, fn)
+}
+
+// update runs the transaction if the version of the vhosts list is the given version ( or anyVersion )
+func (v *Vhosts) update(version int64, fn func(tx *Tx) error) error {
+	v.mutex.Lock()
+	defer v.unlock()
+	if err := v.checkVersion(version); err != nil {
+		return err
+	}
+	if v.index == nil {
+		v.reindex()
+	}
+
+	tx := &Tx{
+		v:      v,
+		vhosts: slices.Clone(v.Vhosts),
+		index:  maps.Clone(v.index),
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if !tx.changed {
+		return nil
+	}
+
+	// drop the middleware chains, the mounted apps and the panics of the removed vhosts
+	var chains []string
+	for _, key := range tx.removed {
+		if _, ok := v.vhostMiddleware[key]; ok {
+			delete(v.vhostMiddleware, key)
+			chains = append(chains, key)
+		}
+		delete(v.apps, key)
+	}
+	v.forgetPanics(tx.removed...)
+	if len(chains) > 0 {
+		v.publishChains(false, chains, nil)
+	}
+	v.Vhosts = tx.vhosts
+	v.index = tx.index
+	v.publish(func(table *routingTable) {
+		table.resetVhosts(v.Vhosts)
+		table.apps = maps.Clone(v.apps)
+	})
+	v.touch()
+	for _, event := range tx.events {
+		event.Version = v.Version
+		v.emit(event)
+	}
+	return nil
+}
+
+// Add stages a vhost, it returns an error if its hostname or one of its aliases is already used or if its path
+// or one of its routes uses a handler tag that doesn't exist
+func (tx *Tx) Add(vhost Vhost) error {
+	entries, err := validateVhost(tx.index, vhost)
+	if err != nil {
+		return err
+	}
+	if err := tx.validateTags(vhost); err != nil {
+		return err
+	}
+	tx.vhosts = append(tx.vhosts, vhost)
+	for _, entry := range entries {
+		for _, suffix := range entry.suffixes {
+			tx.index[entry.host+suffix] = len(tx.vhosts) - 1
+		}
+	}
+	tx.events = append(tx.events, Event{Type: EventAdded, Hostname: vhost.Hostname, New: &vhost})
+	tx.changed = true
+	return nil
+}
+
+// validateTags checks the handler tags of the vhost, the path is a handler or error handler tag ( see
+// SetHandlerByTag and SetErrorHandlerByTag ) and every route needs a handler
+func (tx *Tx) validateTags(vhost Vhost) error {
+	if vhost.Path != "" {
+		_, handler := tx.v.handlers[vhost.Path]
+		_, errorHandler := tx.v.errorHandlers[vhost.Path]
+		if !handler && !errorHandler {
+			return errors.New("handler not found")
+		}
+	}
+	for _, route := range vhost.Routes {
+		if _, ok := tx.v.handlers[route.HandlerTag]; !ok {
+			return errors.New("handler not found")
+		}
+	}
+	return nil
+}
+
+// Get returns the staged vhost with the given hostname or alias ( host:port for a vhost bound to a port )
+func (tx *Tx) Get(hostname string) (Vhost, bool) {
+	i, ok := tx.index[indexKey(hostname)]
+	if !ok {
+		return Vhost{}, false
+	}
+	return tx.vhosts[i], true
+}
+
+// Remove stages the removal of the vhost with the given hostname or alias ( host:port for a vhost bound to a port )
+func (tx *Tx) Remove(hostname string) error {
+	i, ok := tx.index[indexKey(hostname)]
+	if !ok {
+		return errors.New("vhost not found")
+	}
+	removed := tx.vhosts[i]
+	tx.removed = append(tx.removed, vhostKey(removed))
+	tx.events = append(tx.events, Event{Type: EventRemoved, Hostname: removed.Hostname, Old: &removed})
+	tx.vhosts = slices.Delete(tx.vhosts, i, i+1)
+	tx.index = positions(tx.vhosts)
+	tx.changed = true
+	return nil
+}
+
+// SetHandler stages the handler of the vhost with the given hostname
+func (tx *Tx) SetHandler(hostname string, handler FiberHandler) error {
+	return tx.update(hostname, EventHandlerChanged, func(vhost *Vhost) error {
+		vhost.Handler = handler
+		return nil
+	})
+}
+
+// SetHandlerByTag stages the handler of the vhost with the given hostname to the handler with the given tag
+func (tx *Tx) SetHandlerByTag(hostname, tag string) error {
+	return tx.update(hostname, EventHandlerChanged, func(vhost *Vhost) error {
+		handler, ok := tx.v.handlers[tag]
+		if !ok {
+			return errors.New("handler not found")
+		}
+		vhost.Handler = handler
+		// set the path to the handler tag
+		vhost.Path = tag
+		return nil
+	})
+}
+
+// SetErrorHandler stages the error handler of the vhost with the given hostname
+func (tx *Tx) SetErrorHandler(hostname string, errorHandler FiberErrorHandler) error {
+	return tx.update(hostname, EventErrorHandlerChanged, func(vhost *Vhost) error {
+		vhost.ErrorHandler = errorHandler
+		return nil
+	})
+}
+
+// SetErrorHandlerByTag stages the error handler of the vhost with the given hostname to the error handler with the given tag
+func (tx *Tx) SetErrorHandlerByTag(hostname, tag string) error {
+	return tx.update(hostname, EventErrorHandlerChanged, func(vhost *Vhost) error {
+		errorHandler, ok := tx.v.errorHandlers[tag]
+		if !ok {
+			return errors.New("error handler not found")
+		}
+		vhost.ErrorHandler = errorHandler
+		// set the path to the error handler tag
+		vhost.Path = tag
+		return nil
+	})
+}
+
+// update applies change to the staged vhost with the given hostname and records an event of the given type,
+// the vhost is left alone if change returns an error
+func (tx *Tx) update(hostname string, eventType EventType, change func(vhost *Vhost) error) error {
+	i, ok := tx.index[indexKey(hostname)]
+	if !ok {
+		return errors.New("vhost not found")
+	}
+	old, vhost := tx.vhosts[i], tx.vhosts[i]
+	if err := change(&vhost); err != nil {
+		return err
+	}
+	vhost.LastModified = time.Now().Unix()
+	tx.vhosts[i] = vhost
+	tx.events = append(tx.events, Event{Type: eventType, Hostname: vhost.Hostname, Old: &old, New: &vhost})
+	tx.changed = true
+	return nil
+}
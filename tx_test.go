@@ -0,0 +1,155 @@
+package vhosts
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVhosts_Update(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	assert.Nil(t, vhosts.AddHandler("blog", mockMiddleware))
+	assert.Nil(t, vhosts.Add(NewVhost("old.example.com", "", "old", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.Add(NewVhost("keep.example.com", "", "keep", nil, mockErrorHandler)))
+
+	err := vhosts.Update(func(tx *Tx) error {
+		if err := tx.Add(NewVhost("new.example.com", "", "new", mockMiddleware, mockErrorHandler)); err != nil {
+			return err
+		}
+		if err := tx.Remove("old.example.com"); err != nil {
+			return err
+		}
+		// staged changes are visible inside the transaction
+		_, ok := tx.Get("old.example.com")
+		assert.False(t, ok)
+		_, ok = tx.Get("new.example.com")
+		assert.True(t, ok)
+		// but not to requests
+		_, ok = vhosts.routing().index.get("new.example.com")
+		assert.False(t, ok)
+		return tx.SetHandlerByTag("keep.example.com", "blog")
+	})
+	assert.Nil(t, err)
+
+	_, ok := vhosts.Get("old.example.com")
+	assert.False(t, ok)
+	_, ok = vhosts.Get("new.example.com")
+	assert.True(t, ok)
+	vhost, ok := vhosts.Get("keep.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "blog", vhost.Path)
+	assert.NotNil(t, vhost.Handler)
+	assert.Equal(t, 2, vhosts.NumberOfVhosts())
+}
+
+func TestVhosts_Update_Rollback(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	assert.Nil(t, vhosts.Add(NewVhost("a.example.com", "", "a", mockMiddleware, mockErrorHandler)))
+	version, table := vhosts.Version, vhosts.routing()
+
+	tests := []struct {
+		name string
+		fn   func(tx *Tx) error
+		err  string
+	}{
+		{"duplicate", func(tx *Tx) error {
+			assert.Nil(t, tx.Add(NewVhost("b.example.com", "", "b", mockMiddleware, mockErrorHandler)))
+			return tx.Add(NewVhost("B.example.com", "", "b", mockMiddleware, mockErrorHandler))
+		}, "vhost already exists"},
+		{"missing handler tag", func(tx *Tx) error {
+			assert.Nil(t, tx.Remove("a.example.com"))
+			assert.Nil(t, tx.Add(NewVhost("b.example.com", "", "b", mockMiddleware, mockErrorHandler)))
+			return tx.SetHandlerByTag("b.example.com", "missing")
+		}, "handler not found"},
+		{"missing path tag", func(tx *Tx) error {
+			return tx.Add(NewVhost("b.example.com", "missing", "b", mockMiddleware, mockErrorHandler))
+		}, "handler not found"},
+		{"missing route tag", func(tx *Tx) error {
+			vhost := NewVhost("b.example.com", "", "b", mockMiddleware, mockErrorHandler)
+			vhost.Routes = []PathRoute{{Prefix: "/blog", HandlerTag: "missing"}}
+			return tx.Add(vhost)
+		}, "handler not found"},
+		{"missing error handler tag", func(tx *Tx) error {
+			return tx.SetErrorHandlerByTag("a.example.com", "missing")
+		}, "error handler not found"},
+		{"missing vhost", func(tx *Tx) error {
+			return tx.Remove("missing.example.com")
+		}, "vhost not found"},
+		{"caller error", func(tx *Tx) error {
+			assert.Nil(t, tx.Remove("a.example.com"))
+			return errors.New("sync failed")
+		}, "sync failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.EqualError(t, vhosts.Update(tt.fn), tt.err)
+			assert.Equal(t, version, vhosts.Version)
+			assert.Same(t, table, vhosts.routing())
+			assert.Equal(t, 1, vhosts.NumberOfVhosts())
+			_, ok := vhosts.Get("a.example.com")
+			assert.True(t, ok)
+			_, ok = vhosts.Get("b.example.com")
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestVhosts_Update_RemovesChain(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	assert.Nil(t, vhosts.Add(NewVhost("a.example.com", "", "a", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.UseVhost("a.example.com", func(c *fiber.Ctx) error { return c.Next() }))
+	assert.Nil(t, vhosts.Mount("a.example.com", fiber.New()))
+
+	assert.Nil(t, vhosts.Update(func(tx *Tx) error {
+		return tx.Remove("a.example.com")
+	}))
+	assert.Empty(t, vhosts.vhostMiddleware)
+	assert.Empty(t, vhosts.routing().apps)
+}
+
+func TestVhosts_Update_Atomic(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+
+	// a routing table holds either none or all of the vhosts of a batch
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for !stop.Load() {
+			table := vhosts.routing()
+			_, first := table.index.get("host0.example.com")
+			_, last := table.index.get("host99.example.com")
+			if first && !last {
+				t.Error("observed a half applied batch")
+				return
+			}
+		}
+	}()
+	for round := 0; round < 50; round++ {
+		assert.Nil(t, vhosts.Update(func(tx *Tx) error {
+			for i := 0; i < 100; i++ {
+				hostname := fmt.Sprintf("host%d.example.com", i)
+				if round%2 == 1 {
+					if err := tx.Remove(hostname); err != nil {
+						return err
+					}
+				} else if err := tx.Add(NewVhost(hostname, "", "", mockMiddleware, mockErrorHandler)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	stop.Store(true)
+	wg.Wait()
+}
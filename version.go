@@ -0,0 +1,63 @@
+package vhosts
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// anyVersion makes a mutation skip the version check
+const anyVersion int64 = -1
+
+// ErrVersionConflict is matched by errors.Is for every *VersionConflictError
+var ErrVersionConflict = errors.New("version conflict")
+
+// VersionConflictError is returned by the *IfVersion mutations when the vhosts list changed since the expected version was read
+type VersionConflictError struct {
+	Expected int64 // expected is the version the mutation was based on
+	Actual   int64 // actual is the current version of the vhosts list
+}
+
+// Error implements the error interface
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("version conflict: expected version %d, current version is %d", e.Expected, e.Actual)
+}
+
+// Is reports whether target is ErrVersionConflict
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
+// GetVersion returns the version of the vhosts list, it is increased by every change to the vhosts
+func (v *Vhosts) GetVersion() int64 {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	return v.Version
+}
+
+// AddIfVersion adds a vhost if the version of the vhosts list is still the given version, it validates the vhost
+// like Add ( unlike Tx.Add the handler tags aren't checked )
+func (v *Vhosts) AddIfVersion(version int64, vhost Vhost) error {
+	return v.add(version, vhost)
+}
+
+// RemoveIfVersion removes the vhost with the given hostname if the version of the vhosts list is still the given version
+func (v *Vhosts) RemoveIfVersion(version int64, hostname string) error {
+	return v.UpdateIfVersion(version, func(tx *Tx) error {
+		return tx.Remove(hostname)
+	})
+}
+
+// checkVersion returns a *VersionConflictError if the version of the vhosts list isn't the given version, the caller must hold the lock
+func (v *Vhosts) checkVersion(version int64) error {
+	if version != anyVersion && version != v.Version {
+		return &VersionConflictError{Expected: version, Actual: v.Version}
+	}
+	return nil
+}
+
+// touch increases the version of the vhosts list and updates its last modified time, the caller must hold the write lock
+func (v *Vhosts) touch() {
+	v.Version++
+	v.LastModified = time.Now().Unix()
+}
@@ -0,0 +1,124 @@
+package vhosts
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVhosts_Version(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	assert.Nil(t, vhosts.AddHandler("blog", mockMiddleware))
+	assert.Equal(t, int64(0), vhosts.GetVersion())
+
+	assert.Nil(t, vhosts.Add(NewVhost("a.example.com", "", "a", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.Add(NewVhost("b.example.com", "", "b", mockMiddleware, mockErrorHandler)))
+	assert.Equal(t, int64(2), vhosts.GetVersion())
+	assert.Nil(t, vhosts.AddRoute("a.example.com", "/blog", "blog", false))
+	assert.Nil(t, vhosts.RemoveRoute("a.example.com", "/blog"))
+	assert.Nil(t, vhosts.Remove("b.example.com"))
+	assert.Equal(t, int64(5), vhosts.GetVersion())
+
+	// a batch is a single version, failed mutations don't count
+	assert.Nil(t, vhosts.Update(func(tx *Tx) error {
+		assert.Nil(t, tx.Add(NewVhost("b.example.com", "", "b", mockMiddleware, mockErrorHandler)))
+		return tx.Add(NewVhost("c.example.com", "", "c", mockMiddleware, mockErrorHandler))
+	}))
+	assert.Error(t, vhosts.Add(NewVhost("c.example.com", "", "c", mockMiddleware, mockErrorHandler)))
+	assert.Error(t, vhosts.Remove("missing.example.com"))
+	assert.Equal(t, int64(6), vhosts.GetVersion())
+}
+
+func TestVhosts_IfVersion(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	assert.Nil(t, vhosts.Add(NewVhost("a.example.com", "", "a", mockMiddleware, mockErrorHandler)))
+	version := vhosts.GetVersion()
+
+	// another admin process changes the vhosts list in between, a path without a handler is accepted like by Add
+	assert.Nil(t, vhosts.AddIfVersion(version, NewVhost("b.example.com", "legacy", "b", mockMiddleware, mockErrorHandler)))
+	assert.ErrorIs(t, vhosts.AddIfVersion(version, NewVhost("c.example.com", "", "c", mockMiddleware, mockErrorHandler)), ErrVersionConflict)
+	assert.Error(t, vhosts.AddIfVersion(version+1, NewVhost("b.example.com", "", "b", mockMiddleware, mockErrorHandler)))
+
+	err := vhosts.RemoveIfVersion(version, "a.example.com")
+	assert.True(t, errors.Is(err, ErrVersionConflict))
+	var conflict *VersionConflictError
+	assert.True(t, errors.As(err, &conflict))
+	assert.Equal(t, version, conflict.Expected)
+	assert.Equal(t, version+1, conflict.Actual)
+	assert.EqualError(t, err, "version conflict: expected version 1, current version is 2")
+	_, ok := vhosts.Get("a.example.com")
+	assert.True(t, ok)
+
+	// the callback isn't called on a conflict
+	called := false
+	assert.ErrorIs(t, vhosts.UpdateIfVersion(version, func(tx *Tx) error {
+		called = true
+		return nil
+	}), ErrVersionConflict)
+	assert.False(t, called)
+
+	assert.Nil(t, vhosts.RemoveIfVersion(conflict.Actual, "a.example.com"))
+	assert.Equal(t, version+2, vhosts.GetVersion())
+}
+
+func TestVhosts_IfVersion_Concurrent(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	version := vhosts.GetVersion()
+
+	// only one of the writers based on the same version wins
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- vhosts.AddIfVersion(version, NewVhost(string(rune('a'+i))+".example.com", "", "", mockMiddleware, mockErrorHandler))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	won := 0
+	for err := range errs {
+		if err == nil {
+			won++
+		} else {
+			assert.ErrorIs(t, err, ErrVersionConflict)
+		}
+	}
+	assert.Equal(t, 1, won)
+	assert.Equal(t, 1, vhosts.NumberOfVhosts())
+	assert.Equal(t, version+1, vhosts.GetVersion())
+}
+
+func TestVhosts_IfVersion_Load(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vhosts.bin")
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	assert.Nil(t, vhosts.Add(NewVhost("a.example.com", "", "a", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.Save(file))
+	assert.Nil(t, vhosts.Add(NewVhost("b.example.com", "", "b", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.Add(NewVhost("c.example.com", "", "c", mockMiddleware, mockErrorHandler)))
+	version := vhosts.GetVersion()
+
+	// loading an older file doesn't bring back an older version number
+	assert.Nil(t, vhosts.Load(file))
+	assert.Equal(t, version+1, vhosts.GetVersion())
+	assert.Nil(t, vhosts.Add(NewVhost("d.example.com", "", "d", mockMiddleware, mockErrorHandler)))
+	assert.Nil(t, vhosts.Add(NewVhost("e.example.com", "", "e", mockMiddleware, mockErrorHandler)))
+	assert.ErrorIs(t, vhosts.RemoveIfVersion(version, "a.example.com"), ErrVersionConflict)
+	assert.ErrorIs(t, vhosts.RemoveIfVersion(1, "a.example.com"), ErrVersionConflict)
+	assert.Nil(t, vhosts.RemoveIfVersion(vhosts.GetVersion(), "a.example.com"))
+
+	// reloading the handlers is a change too
+	version = vhosts.GetVersion()
+	assert.Nil(t, vhosts.ReloadHandlers())
+	assert.Equal(t, version+1, vhosts.GetVersion())
+	assert.ErrorIs(t, vhosts.RemoveIfVersion(version, "d.example.com"), ErrVersionConflict)
+}
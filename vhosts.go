@@ -1,17 +1,20 @@
 package vhosts
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/base64"
-	"encoding/gob"
 	"errors"
+	"maps"
 	"os"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
+	"github.com/valyala/fasthttp"
 )
 
 // vhosts is the vhosts list
@@ -19,28 +22,69 @@ var Vhs *Vhosts
 
 // Vhost is a virtual host
 type Vhost struct {
-	Hostname     string            // hostname is the hostname of the vhost
-	Path         string            // path is the path of the vhost
-	WebsiteID    string            // websiteID is the websiteID of the vhost
-	ErrorHandler FiberErrorHandler // errorHandler is the error handler for the vhost
-	Handler      FiberHandler      // middleware is the middleware for the vhost
-	LastModified int64             // lastModified is the last modified time of the vhost
+	Hostname       string            // hostname is the hostname of the vhost
+	Path           string            // path is the path of the vhost
+	WebsiteID      string            // websiteID is the websiteID of the vhost
+	ErrorHandler   FiberErrorHandler // errorHandler is the error handler for the vhost
+	Handler        FiberHandler      // middleware is the middleware for the vhost
+	LastModified   int64             // lastModified is the last modified time of the vhost
+	Ports          []int             // ports restricts the vhost to the given ports ( empty matches any port )
+	Aliases        []string          // aliases are additional hostnames ( www., legacy or vanity domains ) resolving to the vhost
+	Canonical      CanonicalPolicy   // canonical is the policy used to redirect requests to the canonical hostname
+	RedirectStatus int               // redirectStatus is the status of canonical redirects ( 301 by default, 308 keeps the method )
+	Routes         []PathRoute       // routes maps path prefixes to handler tags ( the longest prefix wins )
 }
 
 // vhosts contains all the vhosts protected by mutex lock for concurrent access safety
 type Vhosts struct {
-	// vhosts is the list of vhosts
+	// vhosts is the list of vhosts, a list set directly ( &Vhosts{Vhosts: ...} ) is indexed by the first lookup,
+	// change it with Add, Remove or Update afterwards
 	Vhosts []Vhost
 	// LastModified is the last modified time of the vhosts file
 	LastModified int64
-	// Version is the version of the vhosts file ( quick way to check if the vhosts file has changed )
+	// Version is the version of the vhosts file, increased by every change ( quick way to check if the vhosts file has changed )
 	Version int64
 	// Checksum is the checksum of the vhosts file ( quick way to check if the vhosts file has changed )
 	Checksum string
+	// Checksums maps the normalized hostname ( and port ) of every vhost to the checksum of the vhost
+	Checksums map[string]string
 	// Handlers is the list of handlers for the vhosts
 	handlers map[string]FiberHandler
 	// ErrorHandlers is the list of error handlers for the vhosts
 	errorHandlers map[string]FiberErrorHandler
+	// index maps the normalized hostname to the position of the vhost in the vhosts list
+	index map[string]int
+	// table is the routing table read by the request path, replaced on every mutation
+	table atomic.Pointer[routingTable]
+	// defaultErrorHandler handles the errors of vhosts without an error handler
+	defaultErrorHandler FiberErrorHandler
+	// middleware is the global middleware chain executed for every vhost
+	middleware []FiberHandler
+	// vhostMiddleware maps the key of a vhost to its middleware chain
+	vhostMiddleware map[string][]FiberHandler
+	// handlerMiddleware maps a handler tag to its middleware chain
+	handlerMiddleware map[string][]FiberHandler
+	// apps maps the key of a vhost to the request handler of the fiber app mounted on it
+	apps map[string]fasthttp.RequestHandler
+	// panics maps the key of a vhost to the times of its recent panics
+	panics map[string][]time.Time
+	// quarantine maps the key of a quarantined vhost to the end of its quarantine ( zero until released )
+	quarantine map[string]time.Time
+	// panicMutex protects panics and quarantine
+	panicMutex sync.Mutex
+	// subscribers is the list of callbacks registered with Subscribe
+	subscribers []*subscription
+	// subscriberMutex protects subscribers
+	subscriberMutex sync.Mutex
+	// pending holds the events of the current change until the write lock is released
+	pending []Event
+	// emitted is the number of changes with events, numbered while holding the write lock
+	emitted uint64
+	// delivered is the number of the last change whose events were delivered, protected by emitMutex
+	delivered uint64
+	// emitMutex and emitTurn keep the events of concurrent changes in order
+	emitMutex sync.Mutex
+	emitTurn  *sync.Cond
 	// mutex is the mutex lock for concurrent access safety
 	mutex sync.RWMutex
 }
@@ -59,47 +103,134 @@ func NewVhost(hostname, path, websiteID string, handler FiberHandler, errorHandl
 
 // add adds a vhost to the vhosts list
 func (v *Vhosts) Add(vhost Vhost) error {
-	// lookup the vhost by hostname and return error if it already exists
-	_, ok := v.Get(vhost.Hostname)
-	if ok {
-		return errors.New("vhost already exists")
-	}
+	return v.add(anyVersion, vhost)
+}
+
+// add adds the vhost if the version of the vhosts list is the given version ( or anyVersion )
+func (v *Vhosts) add(version int64, vhost Vhost) error {
 	v.mutex.Lock()
-	defer v.mutex.Unlock()
+	defer v.unlock()
+	if err := v.checkVersion(version); err != nil {
+		return err
+	}
+	if v.index == nil {
+		v.reindex()
+	}
+	entries, err := validateVhost(v.index, vhost)
+	if err != nil {
+		return err
+	}
 	v.Vhosts = append(v.Vhosts, vhost)
-	// update the vhosts list version and last modified time
-	v.Version = +1
-	v.LastModified = time.Now().Unix()
+	for _, entry := range entries {
+		for _, suffix := range entry.suffixes {
+			v.index[entry.host+suffix] = len(v.Vhosts) - 1
+		}
+	}
+	// the routing table gets its own copy, the event gets another one ( see emit )
+	published := vhost
+	v.publish(func(table *routingTable) {
+		table.addVhost(&published)
+	})
+	v.touch()
+	v.emit(Event{Type: EventAdded, Hostname: vhost.Hostname, New: &vhost, Version: v.Version})
 
 	return nil
 }
 
-// get returns the vhost with the given hostname
-func (v *Vhosts) Get(hostname string) (Vhost, bool) {
-	v.mutex.RLock()
-	defer v.mutex.RUnlock()
-	for _, vhost := range v.Vhosts {
-		if vhost.Hostname == hostname {
-			return vhost, true
+// validateVhost checks the hostname and aliases of the vhost and that none of them is already in the index
+func validateVhost(index map[string]int, vhost Vhost) ([]hostEntry, error) {
+	entries := vhostEntries(vhost)
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if isHostPattern(entry.host) {
+			if _, err := compileHostPattern(entry.host); err != nil {
+				return nil, err
+			}
+		} else if err := validateWildcard(entry.host); err != nil {
+			return nil, err
+		}
+		// lookup the vhost by hostname or alias ( and port ) and return error if it already exists
+		for _, suffix := range entry.suffixes {
+			if _, ok := index[entry.host+suffix]; ok || seen[entry.host+suffix] {
+				return nil, errors.New("vhost already exists")
+			}
+			seen[entry.host+suffix] = true
 		}
 	}
-	return Vhost{}, false
+	return entries, nil
 }
 
-// remove removes the vhost with the given hostname
+// get returns the vhost with the given hostname, falling back to wildcard vhosts if there is no exact match
+func (v *Vhosts) Get(hostname string) (Vhost, bool) {
+	vhost, _, ok := v.Match(hostname)
+	return vhost, ok
+}
+
+// remove removes the vhost with the given hostname or alias ( host:port for a vhost bound to a port )
 func (v *Vhosts) Remove(hostname string) error {
 	v.mutex.Lock()
-	defer v.mutex.Unlock()
-	for i, vhost := range v.Vhosts {
-		if vhost.Hostname == hostname {
-			v.Vhosts = append(v.Vhosts[:i], v.Vhosts[i+1:]...)
-			// update the vhosts list version and last modified time
-			v.Version = +1
-			v.LastModified = time.Now().Unix()
-			return nil
+	defer v.unlock()
+	i, ok := v.index[indexKey(hostname)]
+	if !ok {
+		return errors.New("vhost not found")
+	}
+	// drop the middleware chain, the mounted app and the panics of the vhost
+	key := vhostKey(v.Vhosts[i])
+	if _, ok := v.vhostMiddleware[key]; ok {
+		delete(v.vhostMiddleware, key)
+		v.publishChains(false, []string{key}, nil)
+	}
+	delete(v.apps, key)
+	v.forgetPanics(key)
+	removed := v.Vhosts[i]
+	v.Vhosts = append(v.Vhosts[:i], v.Vhosts[i+1:]...)
+	// positions after the removed vhost have shifted, rebuild the index
+	v.indexPositions()
+	v.publish(func(table *routingTable) {
+		table.removeVhost(removed)
+		table.apps = maps.Clone(v.apps)
+	})
+	v.touch()
+	v.emit(Event{Type: EventRemoved, Hostname: removed.Hostname, Old: &removed, Version: v.Version})
+	return nil
+}
+
+// reindex rebuilds the hostname index from the vhosts list and publishes a new routing table, the caller must hold the write lock
+func (v *Vhosts) reindex() {
+	v.indexPositions()
+	v.publish(func(table *routingTable) {
+		table.resetVhosts(v.Vhosts)
+		table.handlers = maps.Clone(v.handlers)
+		table.errorHandlers = maps.Clone(v.errorHandlers)
+		table.defaultErrorHandler = v.defaultErrorHandler
+		table.apps = maps.Clone(v.apps)
+	})
+}
+
+// indexPositions rebuilds the map of hostnames to positions in the vhosts list used by the mutations, the caller must hold the write lock
+func (v *Vhosts) indexPositions() {
+	v.index = positions(v.Vhosts)
+}
+
+// positions maps the hostnames and aliases ( and ports ) of the vhosts to their position in the list
+func positions(vhosts []Vhost) map[string]int {
+	index := make(map[string]int, len(vhosts))
+	for i, vhost := range vhosts {
+		for _, entry := range vhostEntries(vhost) {
+			for _, suffix := range entry.suffixes {
+				index[entry.host+suffix] = i
+			}
 		}
 	}
-	return errors.New("vhost not found")
+	return index
+}
+
+// updateVhost replaces the vhost at position i and publishes it, its hostname and aliases must not change, the caller must hold the write lock
+func (v *Vhosts) updateVhost(i int, vhost Vhost) {
+	v.Vhosts[i] = vhost
+	v.publish(func(table *routingTable) {
+		table.replaceVhost(&vhost)
+	})
 }
 
 // NumberOfVhosts returns the length of the vhosts list
@@ -121,14 +252,15 @@ func (v *Vhosts) AddHandler(handlerTag string, handler FiberHandler) error {
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
 	v.handlers[handlerTag] = handler
+	v.publish(func(table *routingTable) {
+		table.handlers = maps.Clone(v.handlers)
+	})
 	return nil
 }
 
 // GetHandler returns the handler for the given handler tag ( string )
 func (v *Vhosts) GetHandler(handlerTag string) (FiberHandler, bool) {
-	v.mutex.RLock()
-	defer v.mutex.RUnlock()
-	handler, ok := v.handlers[handlerTag]
+	handler, ok := v.routing().handlers[handlerTag]
 	return handler, ok
 }
 
@@ -137,17 +269,34 @@ func (v *Vhosts) AddErrorHandler(errorHandlerTag string, errorHandler FiberError
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
 	v.errorHandlers[errorHandlerTag] = errorHandler
+	v.publish(func(table *routingTable) {
+		table.errorHandlers = maps.Clone(v.errorHandlers)
+	})
 	return nil
 }
 
 // GetErrorHandler returns the error handler for the given error handler tag ( string )
 func (v *Vhosts) GetErrorHandler(errorHandlerTag string) (FiberErrorHandler, bool) {
-	v.mutex.RLock()
-	defer v.mutex.RUnlock()
-	errorHandler, ok := v.errorHandlers[errorHandlerTag]
+	errorHandler, ok := v.routing().errorHandlers[errorHandlerTag]
 	return errorHandler, ok
 }
 
+// SetDefaultErrorHandler sets the error handler for the errors of vhosts without an error handler
+func (v *Vhosts) SetDefaultErrorHandler(errorHandler FiberErrorHandler) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.defaultErrorHandler = errorHandler
+	v.publish(func(table *routingTable) {
+		table.defaultErrorHandler = errorHandler
+	})
+}
+
+// DefaultErrorHandler returns the error handler for the errors of vhosts without an error handler
+func (v *Vhosts) DefaultErrorHandler() (FiberErrorHandler, bool) {
+	errorHandler := v.routing().defaultErrorHandler
+	return errorHandler, errorHandler != nil
+}
+
 // RemoveHandler removes the handler with the given handler tag ( string ) and return error if it doesn't exist
 func (v *Vhosts) RemoveHandler(handlerTag string) error {
 	v.mutex.Lock()
@@ -159,6 +308,9 @@ func (v *Vhosts) RemoveHandler(handlerTag string) error {
 	}
 
 	delete(v.handlers, handlerTag)
+	v.publish(func(table *routingTable) {
+		table.handlers = maps.Clone(v.handlers)
+	})
 	return nil
 }
 
@@ -173,6 +325,9 @@ func (v *Vhosts) RemoveErrorHandler(errorHandlerTag string) error {
 	}
 
 	delete(v.errorHandlers, errorHandlerTag)
+	v.publish(func(table *routingTable) {
+		table.errorHandlers = maps.Clone(v.errorHandlers)
+	})
 	return nil
 }
 
@@ -190,7 +345,12 @@ func (v *Vhosts) ReloadHandlers() error {
 	}
 
 	v.mutex.Lock()
-	defer v.mutex.Unlock()
+	defer v.unlock()
+
+	// prefer the default error handler of the registry ( if any )
+	if v.defaultErrorHandler != nil {
+		defaultErrorHandler = v.defaultErrorHandler
+	}
 
 	// loop through the vhosts list
 	for i, vhost := range v.Vhosts {
@@ -237,6 +397,11 @@ func (v *Vhosts) ReloadHandlers() error {
 		}
 	}
 
+	// keep the hostname index in sync with the vhosts list
+	v.reindex()
+	v.touch()
+	v.emit(Event{Type: EventReloaded, Version: v.Version})
+
 	return nil
 
 }
@@ -265,36 +430,30 @@ func (v *Vhosts) GetVhostnames() []string {
 
 // getHandler returns the handler for the given hostname
 func (v *Vhosts) getHandler(hostname string) (FiberHandler, bool) {
-	v.mutex.RLock()
-	defer v.mutex.RUnlock()
-	for _, vhost := range v.Vhosts {
-		if vhost.Hostname == hostname {
-			return vhost.Handler, true
-		}
+	vhost, ok := v.routing().index.get(indexKey(hostname))
+	if !ok {
+		return nil, false
 	}
-	return nil, false
+	return vhost.Handler, true
 }
 
-// Save saves the vhosts to the given file
-func (v *Vhosts) Save(file string) error {
-	v.mutex.RLock()
-	defer v.mutex.RUnlock()
-
-	// hash the vhosts list
-	hash, err := Hash(v.Vhosts)
-	if err != nil {
-		return err
-	}
+// Save saves the vhosts to the given file, as JSON or YAML for the .json, .yaml and .yml extensions and gob otherwise.
+// The file is replaced atomically, a crash while saving leaves the previous file in place.
+// The format version is stored with the vhosts list ( see FormatVersion ), Load migrates files of older formats.
+func (v *Vhosts) Save(file string, opts ...FileOption) error {
+	// the write lock keeps concurrent saves from racing on the checksum and the backups
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
 
-	// update the vhosts list checksum
-	v.Checksum = hash
+	// update the vhosts list checksums
+	v.updateChecksums()
 
-	return save(file, v)
+	return save(file, v, newFileOptions(file, opts))
 }
 
 // save saves the vhosts to the given file
-func save(file string, v *Vhosts) error {
-	return EncodeAsGob(file, v)
+func save(file string, v *Vhosts, o fileOptions) error {
+	return encodeFile(file, v, o)
 }
 
 // Base64Encode encodes the given data as base64 encoded string
@@ -307,35 +466,31 @@ func Base64Encode(data []byte) string {
 
 // EncodeAsGob encodes the given vhosts as gob and saves it to the given file
 func EncodeAsGob(file string, v *Vhosts) error {
-
-	// Open the file at the given path
-	saveFile, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	defer saveFile.Close()
-
-	encoder := gob.NewEncoder(saveFile)
-	err = encoder.Encode(v)
-	if err != nil {
-		return err
-	}
-	return nil
+	return encodeFile(file, v, fileOptions{codec: GobCodec})
 }
 
-// Load loads the vhosts from the given file
-func (v *Vhosts) Load(file string) error {
+// Load loads the vhosts from the given file, the format is chosen like Save does. With WithBackups the backups
+// of the file are tried when the file is missing, can't be decoded or fails checksum verification.
+// Loading increases the version past both the current version and the version stored in the file.
+func (v *Vhosts) Load(file string, opts ...FileOption) error {
+	o := newFileOptions(file, opts)
 
-	// does the file we're trying to load exist?
-	if !doesFileExist(file) {
-		return errors.New("file doesn't exist")
-	}
+	v.mutex.Lock()
+	defer v.unlock()
 
-	// load the vhosts from the given file
-	err := load(file, v)
+	// load the vhosts from the given file ( or one of its backups )
+	current := v.Version
+	err := loadWithBackups(file, v, o)
 	if err != nil {
 		return err
 	}
+	// the version never goes backwards, a version number seen before must not match a different list
+	v.Version = max(current, v.Version) + 1
+
+	// rebuild the hostname index for the loaded vhosts list, the vhosts that are gone lose their quarantine
+	v.reindex()
+	v.retainPanics(v.Vhosts)
+	v.emit(Event{Type: EventLoaded, Version: v.Version})
 
 	// // set the vhosts
 	// v.mutex.Lock()
@@ -346,35 +501,111 @@ func (v *Vhosts) Load(file string) error {
 }
 
 // load loads the vhosts from the given file into the pointer to vhosts
-func load(file string, vhPtr *Vhosts) error {
+func load(file string, vhPtr *Vhosts, o fileOptions) error {
 
-	// Open the file at the given path
-	loadFile, err := os.OpenFile(file, os.O_RDONLY, 0644)
+	// decode the vhosts list, vhPtr is left alone if the file turns out to be broken
+	layout, format, err := decode(file, o)
 	if err != nil {
 		return err
 	}
 
-	// gob decode the vhosts list
-	decoder := gob.NewDecoder(loadFile)
-	err = decoder.Decode(vhPtr)
+	// verify the vhosts list checksums as written to the file
+	written, err := layout.vhosts()
 	if err != nil {
 		return err
 	}
+	report, err := verifyChecksums(written, format)
+	if err != nil {
+		return err
+	}
+	if !report.Valid {
+		return &ChecksumError{Report: report}
+	}
 
-	// verify the vhosts list checksum
-	hash, err := Hash(vhPtr.Vhosts)
+	// upgrade files of older format versions
+	if format < FormatVersion {
+		if layout, err = migrate(layout, format); err != nil {
+			return err
+		}
+	}
+	loaded, err := layout.vhosts()
 	if err != nil {
 		return err
 	}
-	if hash != vhPtr.Checksum {
-		return errors.New("vhosts list checksum doesn't match")
+	if format < FormatVersion {
+		// the checksums of the file cover the layout of its own format version
+		loaded.updateChecksums()
 	}
 
+	vhPtr.Vhosts = loaded.Vhosts
+	vhPtr.LastModified = loaded.LastModified
+	vhPtr.Version = loaded.Version
+	vhPtr.Checksum = loaded.Checksum
+	vhPtr.Checksums = loaded.Checksums
 	return nil
 
 }
 
-// Hash returns the hash of the given vhosts list
+// decode verifies the signature of the given file, decrypts it and decodes it into the layout of its format version
+// without verifying its checksums. Custom codecs decode into the current layout.
+func decode(file string, o fileOptions) (fileLayout, int, error) {
+
+	// does the file we're trying to load exist?
+	if !doesFileExist(file) {
+		return nil, 0, errors.New("file doesn't exist")
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	payload, err := o.verify(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	payload, err = o.open(payload)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if codec, ok := o.codec.(layoutCodec); ok {
+		return decodePayload(codec, payload)
+	}
+	var decoded Vhosts
+	if err := o.codec.Decode(bytes.NewReader(payload), &decoded); err != nil {
+		return nil, 0, err
+	}
+	return decodedLayout{&decoded}, FormatVersion, nil
+}
+
+// decodedLayout is the layout of a vhosts list decoded by a custom codec
+type decodedLayout struct {
+	v *Vhosts
+}
+
+// vhosts returns the decoded vhosts list
+func (l decodedLayout) vhosts() (*Vhosts, error) {
+	return l.v, nil
+}
+
+// loadWithBackups loads the given file, falling back to its backups ( newest first ) if it can't be loaded
+func loadWithBackups(file string, vhPtr *Vhosts, o fileOptions) error {
+	err := load(file, vhPtr, o)
+	if err == nil {
+		return nil
+	}
+	for i := 1; i <= o.backups; i++ {
+		backup := backupFile(file, i)
+		if load(backup, vhPtr, o) == nil {
+			log.Warnf("vhosts file %s can't be loaded ( %v ), loaded backup %s", file, err, backup)
+			return nil
+		}
+	}
+	return err
+}
+
+// Hash returns the legacy ( version 1 ) checksum of the given vhosts list, it only covers the hostnames and websiteIDs
+// and is kept to load older files, Save writes the checksum of every persisted field ( see ChecksumVersion )
 func Hash(vhosts []Vhost) (string, error) {
 
 	var hashes []string
@@ -436,9 +667,9 @@ func Hash(vhosts []Vhost) (string, error) {
 // 	}
 // }
 
-// Init initializes the vhosts list from a file at the given path
-func InitVHostDataFile(path string) error {
-	return Vhs.Load(path)
+// Init initializes the vhosts list from a file at the given path, the options are the options of Load ( see WithTrustedKeys )
+func InitVHostDataFile(path string, opts ...FileOption) error {
+	return Vhs.Load(path, opts...)
 }
 
 // Initialize initializes the vhosts list with some vhosts defaults map of hostname to middleware ( map[string]func(*fiber.Ctx) error )
@@ -456,7 +687,11 @@ func (v *Vhosts) InitializeHandlerSpace() {
 
 	v.handlers = make(map[string]FiberHandler)
 	v.errorHandlers = make(map[string]FiberErrorHandler)
-
+	// the request path reads the handlers from the routing table
+	v.publish(func(table *routingTable) {
+		table.handlers = maps.Clone(v.handlers)
+		table.errorHandlers = maps.Clone(v.errorHandlers)
+	})
 }
 
 // utility functions
@@ -483,37 +718,48 @@ func GetVhosts() *Vhosts {
 	return Vhs
 }
 
-// SetHandler sets the handler for the given hostname
+// SetHandler sets the handler of the vhost with the given hostname or alias
 func (v *Vhosts) SetHandler(hostname string, handler FiberHandler) error {
-	vhost, ok := v.Get(hostname)
-	if !ok {
-		return errors.New("vhost not found")
-	}
-	// lock the vhosts list
-	v.mutex.Lock()
-	defer v.mutex.Unlock()
-	// set the handler
-	vhost.Handler = handler
-
-	return nil
+	return v.setVhost(hostname, EventHandlerChanged, func(vhost *Vhost) error {
+		vhost.Handler = handler
+		return nil
+	})
 }
 
-// SetHandlerByTag sets the handler for the given hostname
+// SetHandlerByTag sets the handler of the vhost with the given hostname or alias to the handler with the given tag
 func (v *Vhosts) SetHandlerByTag(hostname, tag string) error {
-	vhost, ok := v.Get(hostname)
+	return v.setVhost(hostname, EventHandlerChanged, func(vhost *Vhost) error {
+		handler, ok := v.handlers[tag]
+		if !ok {
+			return errors.New("handler not found")
+		}
+		vhost.Handler = handler
+		// set the path to the handler tag
+		vhost.Path = tag
+		return nil
+	})
+}
+
+// setVhost applies change to the stored vhost with the given hostname or alias, bumps the version and emits an event
+// of the given type. Nothing changes if change returns an error.
+func (v *Vhosts) setVhost(hostname string, eventType EventType, change func(vhost *Vhost) error) error {
+	v.mutex.Lock()
+	defer v.unlock()
+	if v.index == nil {
+		v.reindex()
+	}
+	i, ok := v.index[indexKey(hostname)]
 	if !ok {
 		return errors.New("vhost not found")
 	}
-	// lock the vhosts list
-	v.mutex.Lock()
-	defer v.mutex.Unlock()
-	handler, ok := v.GetHandler(tag)
-	if !ok {
-		return errors.New("handler not found")
+	old, vhost := v.Vhosts[i], v.Vhosts[i]
+	if err := change(&vhost); err != nil {
+		return err
 	}
-	vhost.Handler = handler
-	// set the path to the handler tag
-	vhost.Path = tag
+	vhost.LastModified = time.Now().Unix()
+	v.updateVhost(i, vhost)
+	v.touch()
+	v.emit(Event{Type: eventType, Hostname: vhost.Hostname, Old: &old, New: &vhost, Version: v.Version})
 	return nil
 }
 
@@ -537,67 +783,33 @@ func (v *Vhosts) RUnlock() {
 	v.mutex.RUnlock()
 }
 
-// SetErrorHandler sets the error handler for the given hostname
+// SetErrorHandler sets the error handler of the vhost with the given hostname or alias
 func (v *Vhosts) SetErrorHandler(hostname string, errorHandler FiberErrorHandler) error {
-	vhost, ok := v.Get(hostname)
-	if !ok {
-		return errors.New("vhost not found")
-	}
-
-	// lock the vhosts list
-	v.mutex.Lock()
-	defer v.mutex.Unlock()
-	vhost.ErrorHandler = errorHandler
-
-	return nil
+	return v.setVhost(hostname, EventErrorHandlerChanged, func(vhost *Vhost) error {
+		vhost.ErrorHandler = errorHandler
+		return nil
+	})
 }
 
-// SetErrorHandlerByTag sets the error handler for the given hostname
+// SetErrorHandlerByTag sets the error handler of the vhost with the given hostname or alias to the error handler with the given tag
 func (v *Vhosts) SetErrorHandlerByTag(hostname, tag string) error {
-	vhost, ok := v.Get(hostname)
-	if !ok {
-		return errors.New("vhost not found")
-	}
-	// lock the vhosts list
-	v.mutex.Lock()
-	defer v.mutex.Unlock()
-	errorHandler, ok := v.GetErrorHandler(tag)
-	if !ok {
-		return errors.New("error handler not found")
-	}
-	vhost.ErrorHandler = errorHandler
-	// set the path to the error handler tag
-	vhost.Path = tag
-	return nil
+	return v.setVhost(hostname, EventErrorHandlerChanged, func(vhost *Vhost) error {
+		errorHandler, ok := v.errorHandlers[tag]
+		if !ok {
+			return errors.New("error handler not found")
+		}
+		vhost.ErrorHandler = errorHandler
+		// set the path to the error handler tag
+		vhost.Path = tag
+		return nil
+	})
 }
 
-// Vhost middleware for fiber app to handle virtual hosts based on hostname and path ( if any ). It sets the handler and error handler for the vhost based on the hostname and path ( if any )
+// VhostMiddleware is the vhosts middleware for the package level vhosts list. It routes the request by hostname and
+// path prefix ( see AddRoute ) and falls through to the rest of the app for hostnames without a vhost
 func VhostMiddleware(c *fiber.Ctx) error {
-
-	// get the hostname from the request
-	hostname := c.Hostname()
-
-	// get the path from the request
-	path := c.Path()
-
-	// get the vhost from the vhosts list
-	vhost, ok := Vhs.Get(hostname)
-	if !ok {
-		return c.Next()
-	}
-
-	// set the handler for the vhost
-	err := Vhs.SetHandlerByTag(hostname, path)
-	if err != nil {
-		return err
-	}
-
-	// set the error handler for the vhost
-	err = Vhs.SetErrorHandlerByTag(hostname, path)
-	if err != nil {
-		return err
-	}
-
-	// call the vhost handler
-	return vhost.Handler(c)
+	return vhostMiddleware(c)
 }
+
+// vhostMiddleware is the handler behind VhostMiddleware
+var vhostMiddleware = New(Config{UnknownHost: UnknownHostNext})
@@ -1,7 +1,9 @@
 package vhosts
 
 import (
+	"fmt"
 	"os"
+	"sync"
 	"testing"
 )
 
@@ -307,6 +309,96 @@ func TestSetErrorHandler(t *testing.T) {
 
 }
 
+// Test SetHandlerByTag and SetErrorHandlerByTag update the stored vhost
+func TestSetHandlerByTag(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	vhosts.AddHandler("blog", mockMiddleware)
+	vhosts.AddErrorHandler("blog", mockErrorHandler)
+	if err := vhosts.Add(NewVhost("localhost", "", "1", nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	version := vhosts.GetVersion()
+
+	if err := vhosts.SetHandlerByTag("localhost", "blog"); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if err := vhosts.SetErrorHandlerByTag("localhost", "blog"); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	// the stored vhost and the vhost served to requests are both updated
+	if vhosts.Vhosts[0].Handler == nil || vhosts.Vhosts[0].ErrorHandler == nil || vhosts.Vhosts[0].Path != "blog" {
+		t.Errorf("Expected the stored vhost to be updated, got %+v", vhosts.Vhosts[0])
+	}
+	vhost, _ := vhosts.Get("localhost")
+	if vhost.Handler == nil || vhost.ErrorHandler == nil || vhost.Path != "blog" {
+		t.Errorf("Expected the vhost to be updated, got %+v", vhost)
+	}
+	if vhosts.GetVersion() != version+2 {
+		t.Errorf("Expected version %d, got %d", version+2, vhosts.GetVersion())
+	}
+
+	// unknown tags and vhosts leave the vhost alone
+	if err := vhosts.SetHandlerByTag("localhost", "missing"); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+	if err := vhosts.SetErrorHandlerByTag("localhost", "missing"); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+	if err := vhosts.SetHandler("missing", mockMiddleware); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+	if vhosts.Vhosts[0].Path != "blog" || vhosts.GetVersion() != version+2 {
+		t.Errorf("Expected the vhost to be unchanged, got %+v", vhosts.Vhosts[0])
+	}
+}
+
+// Test the setters against concurrent requests and setters ( run with -race )
+func TestSetHandler_Concurrent(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhosts.InitializeHandlerSpace()
+	vhosts.AddHandler("a", mockMiddleware)
+	vhosts.AddHandler("b", mockMiddleware)
+	vhosts.AddErrorHandler("a", mockErrorHandler)
+	if err := vhosts.Add(NewVhost("localhost", "", "1", mockMiddleware, mockErrorHandler)); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				tag := "a"
+				if (i+j)%2 == 0 {
+					tag = "b"
+				}
+				if err := vhosts.SetHandlerByTag("localhost", tag); err != nil {
+					t.Error(err)
+				}
+				if err := vhosts.SetErrorHandler("localhost", mockErrorHandler); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if handler, ok := vhosts.getHandler("localhost"); !ok || handler == nil {
+					t.Error("Expected handler to be set")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if vhosts.GetVersion() != 1+8*100*2 {
+		t.Errorf("Expected version %d, got %d", 1+8*100*2, vhosts.GetVersion())
+	}
+}
+
 // Set handler for vhost that doesn't exist
 func TestSetHandler_VhostDoesntExist(t *testing.T) {
 
@@ -377,6 +469,129 @@ func TestSetErrorHandler_VhostDoesntExist(t *testing.T) {
 
 }
 
+// Test that lookups go through the hostname index and it stays in sync after Remove
+func TestVhosts_Get_Index(t *testing.T) {
+	vhosts := &Vhosts{}
+	for i := 0; i < 100; i++ {
+		vhosts.Add(NewVhost(fmt.Sprintf("host%d.example.com", i), "", fmt.Sprint(i), mockMiddleware, mockErrorHandler))
+	}
+
+	// lookups are case insensitive
+	vhost, ok := vhosts.Get("HOST42.example.com")
+	if !ok || vhost.WebsiteID != "42" {
+		t.Errorf("Expected to get vhost 'host42.example.com', got '%s'", vhost.Hostname)
+	}
+
+	// removing a vhost shifts the positions of the following vhosts
+	if err := vhosts.Remove("host10.example.com"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, ok := vhosts.Get("host10.example.com"); ok {
+		t.Errorf("Expected vhost 'host10.example.com' to be removed")
+	}
+	vhost, ok = vhosts.Get("host99.example.com")
+	if !ok || vhost.WebsiteID != "99" {
+		t.Errorf("Expected to get vhost 'host99.example.com', got '%s'", vhost.Hostname)
+	}
+
+	// adding a vhost with a different case is a duplicate
+	if err := vhosts.Add(NewVhost("Host1.Example.com", "", "", mockMiddleware, mockErrorHandler)); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+// Test that a vhosts list set without Add is found by the lookups
+func TestVhosts_Get_Literal(t *testing.T) {
+	vhosts := &Vhosts{Vhosts: []Vhost{NewVhost("Example.com", "", "1", mockMiddleware, mockErrorHandler)}}
+	if vhosts.NumberOfVhosts() != 1 {
+		t.Errorf("Expected 1 vhost, got %d", vhosts.NumberOfVhosts())
+	}
+	vhost, ok := vhosts.Get("example.com")
+	if !ok || vhost.WebsiteID != "1" {
+		t.Errorf("Expected to get vhost 'example.com', got '%s'", vhost.Hostname)
+	}
+
+	// the list is indexed for the mutations as well
+	if err := vhosts.Add(NewVhost("example.com", "", "", mockMiddleware, mockErrorHandler)); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+// Test that aliases resolve to the same vhost, are checked for duplicates and survive Save/Load
+func TestVhosts_Aliases(t *testing.T) {
+	vhosts := &Vhosts{}
+	vhost := NewVhost("example.com", "site", "1", mockMiddleware, mockErrorHandler)
+	vhost.Aliases = []string{"www.example.com", "example.net"}
+	if err := vhosts.Add(vhost); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	for _, hostname := range []string{"example.com", "WWW.example.com", "example.net"} {
+		gotVhost, ok := vhosts.Get(hostname)
+		if !ok || gotVhost.Hostname != "example.com" {
+			t.Errorf("Expected '%s' to resolve to 'example.com', got '%s'", hostname, gotVhost.Hostname)
+		}
+	}
+
+	// an alias can't be used as hostname or alias of another vhost
+	if err := vhosts.Add(NewVhost("example.net", "", "2", mockMiddleware, mockErrorHandler)); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+	other := NewVhost("example.org", "", "2", mockMiddleware, mockErrorHandler)
+	other.Aliases = []string{"www.example.com"}
+	if err := vhosts.Add(other); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+	// nor twice within the same vhost
+	other.Aliases = []string{"www.example.org", "WWW.example.org"}
+	if err := vhosts.Add(other); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+
+	// the aliases are persisted
+	file := t.TempDir() + "/aliases.bin"
+	if err := vhosts.Save(file); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	loaded := &Vhosts{}
+	if err := loaded.Load(file); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if gotVhost, ok := loaded.Get("example.net"); !ok || gotVhost.Hostname != "example.com" {
+		t.Errorf("Expected 'example.net' to resolve to 'example.com' after Load")
+	}
+
+	// removing by alias removes the whole vhost
+	if err := vhosts.Remove("www.example.com"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	for _, hostname := range []string{"example.com", "www.example.com", "example.net"} {
+		if _, ok := vhosts.Get(hostname); ok {
+			t.Errorf("Expected '%s' to be removed", hostname)
+		}
+	}
+}
+
+// Benchmark Get with a growing number of vhosts, the time per lookup should stay flat
+func BenchmarkVhosts_Get(b *testing.B) {
+	for _, n := range []int{10, 1000, 10000, 100000} {
+		vhosts := &Vhosts{}
+		for i := 0; i < n; i++ {
+			vhosts.Add(NewVhost(fmt.Sprintf("host%d.example.com", i), "", "", mockMiddleware, mockErrorHandler))
+		}
+		hostname := fmt.Sprintf("host%d.example.com", n-1)
+
+		b.Run(fmt.Sprintf("vhosts=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, ok := vhosts.Get(hostname); !ok {
+					b.Fatalf("vhost %s not found", hostname)
+				}
+			}
+		})
+	}
+}
+
 // Clean up
 func TestVhosts_CleanUp(t *testing.T) {
 	os.Remove("test.bin")